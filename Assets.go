@@ -13,7 +13,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 // Howto for plugins
 const TxtPluginHowto = `
@@ -32,14 +32,138 @@ The input parameter "line" is the line of text from the sampling file.
 The parseLine function shall return a boolean (true or false), indicating wether the current line should be
 skipped or not. Returning false will instruct the converter to skip on to the next line.
 
-For the plugin to be valid, it must define five variables: date, latitude, longitude, value and unit.
+A line can also make several samples, or none. Instead of a boolean, parseLine can return an array of
+sample objects, with the same fields as the variables described below, e.g.
+return [{ date: d, latitude: lat, longitude: lon, altitude: alt, value: 0.12, unit: "uSv/h" }, ...].
+Plugins can also call the function emit(sample) with a sample object, any number of times. Emitted samples
+come first, followed by the returned samples. Together with the context object described below, this
+lets a plugin collect one reading spread across several lines and emit it on the last of them.
+
+For the plugin to be valid, it must define six variables: date, latitude, longitude, altitude, value and unit.
 These variables should be set to their respective values in the body of the parseLine function.
 
-- date (string)        => The date the sample was taken, in standard ISO format (yyyy-MM-ddThh:mm:ss)
+- date (string)        => The date the sample was taken, in standard ISO format (yyyy-MM-ddThh:mm:ss).
+                          A number since the Unix epoch or a javascript Date object is also accepted
 - latitude (decimal)   => The latitude where the sample was taken (GPS format)
 - longitude (decimal)  => The longitude where the sample was taken (GPS format)
+- altitude (decimal)   => The altitude where the sample was taken (WGS84 format)
 - value (decimal)      => The measurement value of the sample
 - unit (string)        => The unit of the measurement value
+
+Plugins can set an optional variable "measurements" to an object holding several named measured
+quantities for the same position, like dose rate, total counts and nuclide specific activities.
+Each channel is an object with a value and a unit. If the variable "value" is not defined, the first
+channel is used as the value and unit of the sample. The -use-channel option selects the channel
+used for marker colors and labels.
+
+- measurements (object) => e.g. { "Cs-137": { value: 12.3, unit: "Bq/kg" }, "K-40": { value: 450, unit: "Bq/kg" } }
+
+Plugins can set an optional variable "uncertainty" holding the uncertainty of the value. It is either
+an absolute number with coverage factor 1, or an object with the fields value, relative (the value is
+a fraction of the measured value) and coverage (the coverage factor k). Measurement channels can carry
+an uncertainty in the same way.
+
+- uncertainty (decimal or object) => e.g. 0.02 or { value: 0.05, relative: true, coverage: 2 }
+
+Plugins can set an optional variable "attributes" to an object holding extra values for the sample,
+like count rate, detector ID or speed. The attributes are carried through to every output format,
+e.g. as extra csv columns or kml extended data. The csv columns are taken from the first sample, so
+set every attribute on the first sample. Later samples can leave attributes out, but a sample with an
+attribute the first sample has not stops the csv conversion.
+
+- attributes (object)  => Extra named values (string, decimal or boolean) of the sample
+
+Plugins can also declare the following global variables to control how dates are interpreted.
+Dates are written to all output formats as RFC 3339 timestamps with the time zone offset.
+
+- dateLayout (string)  => The layout of the date strings, written as the Go reference time
+                          Mon Jan 2 15:04:05 MST 2006 would be, or "unix" / "unixmilli" for numbers
+                          of seconds / milliseconds since the Unix epoch. Defaults to 2006-01-02T15:04:05
+- timeZone (string)    => The IANA time zone (e.g. Europe/Oslo) of dates without an offset. Defaults to UTC
+
+Plugins can implement two optional functions, called before the first line and after the last line of a
+sample file. A global object "context" is kept for the whole sample file, so plugins can parse header lines
+once and keep calibration factors, detector serial numbers and the like between lines.
+
+function begin(fileName, fileAttributes)
+{
+        // Called before the first line. fileName is the sample file, "-" for stdin
+}
+
+function end()
+{
+        // Called after the last line. Can make the last samples, like parseLine
+}
+
+The "fileAttributes" object passed to begin starts out empty. Values the plugin sets on it, like a detector
+serial number read from the header, are added as attributes to every sample, unless the sample has an
+attribute of the same name. Keep it in the context to add values found later in the header, e.g.
+context.fileAttributes = fileAttributes in begin and context.fileAttributes.detector = line in parseLine.
+
+Plugins can be given a time budget, to stop plugins that hang. With -plugin-timeout, a plugin spending more
+than the given time (e.g. 5s) loading or parsing a single line is stopped, and the line is rejected with the
+plugin name and line number. Use -plugin-file-timeout to limit the time spent on a whole sample file. The
+budgets are off by default, as timing every line slows down the conversion. Plugins can not
+compile code, eval, Function and the constructor property of functions are not available. console.log
+writes to stderr, prefixed with the plugin name.
+
+Use -test-plugin to check a plugin against one or more fixture files, small sample files with known content.
+The samples of each fixture are compared to the expected samples in a file next to it, named after the
+fixture with the extension .expected.json (e.g. fixture.expected.json for fixture.log). It holds a json array
+with an object for each expected sample, giving the line number of the sample in the fixture file and the
+fields to compare, as they are written by the json format. Samples made by the begin hook have line 0, and
+samples made by the end hook have line "end":
+
+[
+        { "line": 2, "date": "2015-03-01T10:00:00Z", "latitude": 59.9, "value": 0.1, "unit": "uSv/h" },
+        { "line": "end", "value": 0.3, "unit": "uSv/h" }
+]
+
+Lines the plugin fails to parse, like missing variables and unparseable dates, and fields differing from
+the expected samples are reported line by line, e.g. sampleconverter -test-plugin myplugin fixture.log
+
+Simple delimited sample files can be parsed without javascript by a parser definition. A parser
+definition is a JSON file in the plugin directory, named after the plugin (e.g. myplugin.json):
+
+{
+        "delimiter": ",",
+        "skipLines": 0,
+        "header": true,
+        "comment": "#",
+        "columns": {
+                "date": "Time",
+                "latitude": 1,
+                "longitude": 2,
+                "altitude": 3,
+                "value": "Dose rate"
+        },
+        "dateLayout": "2006-01-02T15:04:05",
+        "timeZone": "Europe/Oslo",
+        "unit": "uSv/h",
+        "attributes": {
+                "detector": "Detector ID",
+                "speed": 5
+        },
+        "measurements": {
+                "Cs-137": { "column": 6, "unit": "Bq/kg" }
+        }
+}
+
+- delimiter (string)   => The field delimiter, a single character. Fields holding the delimiter are
+                          quoted, as in csv files. If empty, fields are separated by whitespace
+- skipLines (integer)  => The number of lines to skip at the start of the file
+- header (boolean)     => The first line after the skipped lines holds the column names
+- comment (string)     => Lines starting with this prefix are skipped
+- columns (object)     => The columns holding date, latitude, longitude, altitude, value and unit.
+                          A column is either a zero based index or a column name from the header line.
+                          The date, latitude, longitude and value columns are required
+- dateLayout (string)  => The layout of the date column, as for javascript plugins
+- timeZone (string)    => The IANA time zone of dates without an offset. Defaults to UTC
+- unit (string)        => The unit of the measurement values, used when there is no unit column
+- attributes (object)  => Extra sample attributes, mapping attribute names to columns
+- measurements (object) => Extra measurement channels, mapping channel names to a column and a unit
+- uncertainty (object) => The uncertainty of the values, e.g. { "column": 5, "relative": false, "coverage": 2 }.
+                          Measurement channels can have an uncertainty object as well
 `
 
 // Base64 encoded PNG image
@@ -0,0 +1,293 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Binning methods used to compute class breaks from the values of a file
+const (
+	BinningLinear   = "linear"
+	BinningLog      = "log"
+	BinningQuantile = "quantile"
+)
+
+// Palettes Named color ramps, as kml aabbggrr colors. The colors of a scale are interpolated along the ramp
+var Palettes = map[string][]string{
+	"kmz":       {"FFF0FF14", "FF78FFF0", "FF14B4FF", "FF1400FF"},
+	"irix":      {"FFF0AA14", "FF78FFB4", "FF78FFF0", "FF14B4FF", "FF143CFF"},
+	"viridis":   {"FF540144", "FF8B523B", "FF8C9021", "FF63C95D", "FF25E7FD"},
+	"heat":      {"FF00FF00", "FF00FFFF", "FF0000FF"},
+	"grayscale": {"FFFFFFFF", "FF000000"},
+}
+
+// ColorScale Structure representing the value classes and colors used by the kmz writers.
+// Zero fields are taken from the default scale of the writer
+type ColorScale struct {
+	Classes int       `json:"classes"` // Number of classes computed by the binning method
+	Breaks  []float64 `json:"breaks"`  // Explicit upper limits of all classes but the last. Overrides classes and binning
+	Binning string    `json:"binning"` // Binning method, linear, log or quantile
+	Palette string    `json:"palette"` // Name of the palette the class colors are taken from
+	Colors  []string  `json:"colors"`  // Explicit kml aabbggrr colors, one for each class. Overrides the palette
+}
+
+// ColorClasses Structure representing the value classes of a color scale resolved for a set of values
+type ColorClasses struct {
+	Min    float64   // Smallest value
+	Max    float64   // Largest value
+	Breaks []float64 // Upper limits of all classes but the last
+	Colors []string  // Kml aabbggrr color of each class
+}
+
+// LoadColorScale Read a color scale from a json file
+func LoadColorScale(file string) (*ColorScale, error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := new(ColorScale)
+
+	err = json.Unmarshal(b, cs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return cs, nil
+}
+
+// WithDefaults Get the color scale with zero fields taken from a default scale.
+// The default breaks are only used when neither breaks, classes nor binning are given
+func (cs *ColorScale) WithDefaults(def ColorScale) ColorScale {
+
+	if cs == nil {
+		return def
+	}
+
+	s := *cs
+
+	if len(s.Breaks) == 0 && s.Classes == 0 && len(s.Binning) == 0 {
+		s.Breaks = def.Breaks
+	}
+
+	if s.Classes == 0 {
+		s.Classes = def.Classes
+	}
+
+	if len(s.Binning) == 0 {
+		s.Binning = def.Binning
+	}
+
+	if len(s.Palette) == 0 {
+		s.Palette = def.Palette
+	}
+
+	return s
+}
+
+// NeedsValues Check if the class breaks depend on the values of the file
+func (cs *ColorScale) NeedsValues() bool {
+
+	return len(cs.Breaks) == 0
+}
+
+// Validate Check the color scale for errors
+func (cs *ColorScale) Validate() error {
+
+	for i := 1; i < len(cs.Breaks); i++ {
+		if cs.Breaks[i] <= cs.Breaks[i-1] {
+			return errors.New("Color scale breaks must be increasing")
+		}
+	}
+
+	if len(cs.Breaks) == 0 {
+
+		if cs.Classes < 1 {
+			return errors.New("Color scale needs at least one class")
+		}
+
+		if cs.Binning != BinningLinear && cs.Binning != BinningLog && cs.Binning != BinningQuantile {
+			return errors.New("Binning method not supported: " + cs.Binning)
+		}
+	}
+
+	if len(cs.Colors) > 0 {
+
+		if len(cs.Colors) != cs.numClasses() {
+			return fmt.Errorf("Color scale has %d classes but %d colors", cs.numClasses(), len(cs.Colors))
+		}
+
+		for _, c := range cs.Colors {
+			if _, err := parseKmlColor(c); err != nil {
+				return err
+			}
+		}
+
+	} else if _, ok := Palettes[cs.Palette]; !ok {
+		return errors.New("Palette not supported: " + cs.Palette)
+	}
+
+	return nil
+}
+
+// Get the number of classes of the scale
+func (cs *ColorScale) numClasses() int {
+
+	if len(cs.Breaks) > 0 {
+		return len(cs.Breaks) + 1
+	}
+
+	return cs.Classes
+}
+
+// Resolve Compute the classes of the scale for a set of values given by min and max.
+// The smallest value above zero, minAbove, is only needed for log binning, and the sorted values for quantile binning
+func (cs *ColorScale) Resolve(min, max, minAbove float64, sorted []float64) (*ColorClasses, error) {
+
+	cc := &ColorClasses{Min: min, Max: max}
+
+	n := cs.numClasses()
+
+	switch {
+	case len(cs.Breaks) > 0:
+		cc.Breaks = cs.Breaks
+
+	case cs.Binning == BinningLinear:
+		for i := 1; i < n; i++ {
+			cc.Breaks = append(cc.Breaks, min+(max-min)*float64(i)/float64(n))
+		}
+
+	case cs.Binning == BinningLog:
+		// The classes start at the smallest value above zero, values at or below zero go in the first class
+		for i := 1; i < n; i++ {
+			if minAbove <= 0 {
+				cc.Breaks = append(cc.Breaks, 0)
+				continue
+			}
+			cc.Breaks = append(cc.Breaks, math.Exp(math.Log(minAbove)+(math.Log(max)-math.Log(minAbove))*float64(i)/float64(n)))
+		}
+
+	case cs.Binning == BinningQuantile:
+		for i := 1; i < n; i++ {
+			if len(sorted) == 0 {
+				cc.Breaks = append(cc.Breaks, min)
+				continue
+			}
+			cc.Breaks = append(cc.Breaks, sorted[(len(sorted)-1)*i/n])
+		}
+	}
+
+	if len(cs.Colors) > 0 {
+		cc.Colors = cs.Colors
+	} else {
+		cc.Colors = interpolateColors(Palettes[cs.Palette], n)
+	}
+
+	return cc, nil
+}
+
+// Class Get the index of the class a value belongs to
+func (cc *ColorClasses) Class(value float64) int {
+
+	return sort.Search(len(cc.Breaks), func(i int) bool { return value <= cc.Breaks[i] })
+}
+
+// Range Get the lower and upper limit of a class. The first and last classes are limited by the min and max values
+func (cc *ColorClasses) Range(class int) (float64, float64) {
+
+	lower, upper := cc.Min, cc.Max
+
+	if class > 0 {
+		lower = cc.Breaks[class-1]
+	}
+
+	if class < len(cc.Breaks) {
+		upper = cc.Breaks[class]
+	}
+
+	return lower, upper
+}
+
+// ParseBreaks Parse a comma separated list of class breaks
+func ParseBreaks(s string) ([]float64, error) {
+
+	var breaks []float64
+
+	for _, item := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			return nil, errors.New("Invalid class break: " + item)
+		}
+		breaks = append(breaks, f)
+	}
+
+	return breaks, nil
+}
+
+// Interpolate n colors evenly along a palette
+func interpolateColors(palette []string, n int) []string {
+
+	colors := make([]string, n)
+
+	for i := range colors {
+
+		if n == 1 || len(palette) == 1 {
+			colors[i] = palette[0]
+			continue
+		}
+
+		pos := float64(i) * float64(len(palette)-1) / float64(n-1)
+		j := int(pos)
+		if j >= len(palette)-1 {
+			colors[i] = palette[len(palette)-1]
+			continue
+		}
+
+		c0, _ := parseKmlColor(palette[j])
+		c1, _ := parseKmlColor(palette[j+1])
+		t := pos - float64(j)
+
+		c := make([]byte, 4)
+		for k := range c {
+			c[k] = byte(math.Round(float64(c0[k]) + (float64(c1[k])-float64(c0[k]))*t))
+		}
+
+		colors[i] = strings.ToUpper(hex.EncodeToString(c))
+	}
+
+	return colors
+}
+
+// Parse a kml aabbggrr color
+func parseKmlColor(c string) ([]byte, error) {
+
+	b, err := hex.DecodeString(c)
+	if err != nil || len(b) != 4 {
+		return nil, errors.New("Invalid kml color: " + c)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,90 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"math"
+	"testing"
+)
+
+func TestColorScaleResolve(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		scale    ColorScale
+		min      float64
+		max      float64
+		minAbove float64
+		sorted   []float64
+		breaks   []float64
+		classes  map[float64]int // Class of some values
+	}{
+		{"breaks", ColorScale{Breaks: []float64{1, 5}, Binning: BinningLog}, 0, 10, 0.5, nil,
+			[]float64{1, 5}, map[float64]int{1: 0, 1.1: 1, 5: 1, 10: 2}},
+		{"linear", ColorScale{Classes: 4, Binning: BinningLinear}, -2, 2, 1, nil,
+			[]float64{-1, 0, 1}, map[float64]int{-2: 0, -0.5: 1, 0: 1, 2: 3}},
+		{"log", ColorScale{Classes: 2, Binning: BinningLog}, 1, 100, 1, nil,
+			[]float64{10}, map[float64]int{1: 0, 10: 0, 11: 1}},
+		{"log with values at or below zero", ColorScale{Classes: 3, Binning: BinningLog}, -5, 1000, 10, nil,
+			[]float64{46.415888, 215.443469}, map[float64]int{-5: 0, 0: 0, 10: 0, 100: 1, 1000: 2}},
+		{"log without values above zero", ColorScale{Classes: 3, Binning: BinningLog}, -5, 0, 0, nil,
+			[]float64{0, 0}, map[float64]int{-5: 0, 0: 0}},
+		{"quantile", ColorScale{Classes: 4, Binning: BinningQuantile}, 1, 9, 1, []float64{1, 2, 3, 4, 5, 6, 7, 8, 9},
+			[]float64{3, 5, 7}, map[float64]int{1: 0, 3: 0, 4: 1, 9: 3}},
+		{"quantile with fewer values than classes", ColorScale{Classes: 4, Binning: BinningQuantile}, 1, 2, 1, []float64{1, 2},
+			[]float64{1, 1, 1}, map[float64]int{1: 0, 2: 3}},
+		{"quantile with one value", ColorScale{Classes: 3, Binning: BinningQuantile}, 5, 5, 5, []float64{5},
+			[]float64{5, 5}, map[float64]int{5: 0}},
+		{"quantile without values", ColorScale{Classes: 3, Binning: BinningQuantile}, 0, 0, 0, nil,
+			[]float64{0, 0}, map[float64]int{0: 0}},
+	}
+
+	for _, test := range tests {
+
+		test.scale.Palette = "heat"
+		err := test.scale.Validate()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		cc, err := test.scale.Resolve(test.min, test.max, test.minAbove, test.sorted)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if len(cc.Breaks) != len(test.breaks) {
+			t.Errorf("%s: breaks are %v, expected %v", test.name, cc.Breaks, test.breaks)
+			continue
+		}
+
+		for i, b := range cc.Breaks {
+			if math.Abs(b-test.breaks[i]) > 1e-6 || math.IsNaN(b) {
+				t.Errorf("%s: breaks are %v, expected %v", test.name, cc.Breaks, test.breaks)
+				break
+			}
+		}
+
+		if len(cc.Colors) != len(test.breaks)+1 {
+			t.Errorf("%s: %d colors for %d classes", test.name, len(cc.Colors), len(test.breaks)+1)
+		}
+
+		for value, class := range test.classes {
+			if c := cc.Class(value); c != class {
+				t.Errorf("%s: value %v is in class %d, expected %d", test.name, value, c, class)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+// Package sampleconverter converts sample log files to standard formats like csv, json, xml, kmz etc.
+// Sample files are parsed line by line with plugins by a SampleReader, and the resulting
+// samples are written by one of the SampleWriter implementations.
+package sampleconverter
+
+import (
+	"io"
+)
+
+// WriterOptions Structure representing the options passed to a sample writer
+type WriterOptions struct {
+	Name          string      // Base name of the output, used by writers that embed it in the output
+	Source        string      // Base name of the sample file being converted
+	OutputFile    string      // Path of the output file, used by writers producing more files next to it. Empty for streams
+	UseScientific bool        // Use scientific notation for decimal values
+	UseLabels     bool        // Use labels for markers
+	Channel       string      // Measurement channel used for marker colors and labels. Empty means the primary value
+	UseWaypoints  bool        // Write waypoints instead of a track
+	Table         string      // Database table all sample files are written to. Empty means one table per sample file
+	Track         string      // Write the track as colored line segments, "both" with the placemarks or "only". Empty means no track
+	AltitudeMode  string      // KML altitude mode, "clampToGround", "relativeToGround" or "absolute". Empty means clampToGround
+	ColorScale    *ColorScale // Value classes and colors of markers. Nil means the default scale of the writer
+	Folders       string      // Group placemarks in folders by "file", "day", "hour" or "class". Empty means no folders
+}
+
+// NewSampleWriter Create a sample writer for the given output format writing to w
+func NewSampleWriter(format string, w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	f, err := LookupFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.New(w, opts)
+}
+
+// OutputFile Get the output file name for a sample file converted to the given output format
+func OutputFile(format, sampleFile string) (string, error) {
+
+	f, err := LookupFormat(format)
+	if err != nil {
+		return "", err
+	}
+
+	return f.OutputFile(sampleFile), nil
+}
+
+// Convert Read all samples from sr and write them to sw. Returns the number of samples written.
+// The sample writer is not closed, as some writers do most of their work on close
+func Convert(sr SampleReader, sw SampleWriter) (int, error) {
+
+	return ConvertTolerant(sr, sw, nil)
+}
+
+// ConvertTolerant Read all samples from sr and write them to sw, passing lines the plugin fails to parse
+// to reject and going on with the next line. The conversion stops if reject returns an error, or on
+// the first line error if reject is nil. Returns the number of samples written
+func ConvertTolerant(sr SampleReader, sw SampleWriter, reject func(e *LineError) error) (int, error) {
+
+	n := 0
+
+	for {
+		s, more, err := sr.Read()
+		if err != nil {
+			le, ok := err.(*LineError)
+			if !ok || reject == nil {
+				return n, err
+			}
+
+			err = reject(le)
+			if err != nil {
+				return n, err
+			}
+
+			continue
+		}
+
+		if !more {
+			break
+		}
+
+		err = sw.Write(s)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,101 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Special date layouts for dates given as numbers since the Unix epoch
+const (
+	LayoutUnix      = "unix"      // Seconds since the Unix epoch, fractions allowed
+	LayoutUnixMilli = "unixmilli" // Milliseconds since the Unix epoch
+)
+
+// DefaultDateLayout The layout used for plugin dates when no layout is given
+const DefaultDateLayout = "2006-01-02T15:04:05"
+
+// DateFormat Structure representing how dates from plugins are interpreted
+type DateFormat struct {
+	Layout   string         // Layout using the Go reference time, LayoutUnix or LayoutUnixMilli
+	Location *time.Location // Time zone for dates without an explicit offset
+}
+
+// NewDateFormat Create a date format from a layout and an IANA time zone name.
+// An empty layout means DefaultDateLayout, and an empty time zone means UTC
+func NewDateFormat(layout, timeZone string) (*DateFormat, error) {
+
+	df := new(DateFormat)
+	df.Layout = layout
+	if len(df.Layout) == 0 {
+		df.Layout = DefaultDateLayout
+	}
+
+	var err error
+	df.Location, err = time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	return df, nil
+}
+
+// Parse Interpret a date string. Dates using the default layout may also carry an RFC 3339 offset
+func (df *DateFormat) Parse(s string) (time.Time, error) {
+
+	switch df.Layout {
+	case LayoutUnix, LayoutUnixMilli:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, errors.New("invalid epoch date " + strconv.Quote(s))
+		}
+		return df.FromEpoch(f), nil
+	}
+
+	t, err := time.ParseInLocation(df.Layout, s, df.Location)
+	if err == nil || df.Layout != DefaultDateLayout {
+		return t, err
+	}
+
+	// The error of the declared layout is more useful than the one of the fallback
+	t, rerr := time.Parse(time.RFC3339Nano, s)
+	if rerr != nil {
+		return time.Time{}, err
+	}
+
+	return t, nil
+}
+
+// FromEpoch Interpret a number since the Unix epoch. The number is taken as milliseconds
+// with the LayoutUnixMilli layout and as seconds otherwise
+func (df *DateFormat) FromEpoch(f float64) time.Time {
+
+	if df.Layout == LayoutUnixMilli {
+		f /= 1000
+	}
+
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))).In(df.Location)
+}
+
+// FormatDate Format a date as RFC 3339 with the offset of its time zone
+func FormatDate(t time.Time) string {
+
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,94 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateFormatParse(t *testing.T) {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		layout   string
+		timeZone string
+		input    string
+		date     time.Time
+		err      string
+	}{
+		{"", "", "2015-03-01T10:00:00", date, ""},
+		{"", "Europe/Oslo", "2015-03-01T11:00:00", date, ""},
+		{"", "Europe/Oslo", "2015-03-01T12:00:00+02:00", date, ""},
+		{"", "", "2015-03-01T10:00:00.250Z", date.Add(250 * time.Millisecond), ""},
+		{"", "", "01.03.2015 10:00", time.Time{}, `cannot parse "01.03.2015 10:00" as "2006"`},
+		{"2006-01-02 15:04", "", "2015-03-01 10:00", date, ""},
+		{"2006-01-02 15:04", "", "2015-03-01T10:00:00Z", time.Time{}, `cannot parse "T10:00:00Z" as " "`},
+		{"2006-01-02T15:04:05Z07:00", "", "2015-03-01T10:00:00", time.Time{}, `cannot parse "" as "Z07:00"`},
+		{LayoutUnix, "", "1425204000", date, ""},
+		{LayoutUnix, "", "1425204000.5", date.Add(500 * time.Millisecond), ""},
+		{LayoutUnix, "Europe/Oslo", "1425204000", date, ""},
+		{LayoutUnixMilli, "", "1425204000250", date.Add(250 * time.Millisecond), ""},
+		{LayoutUnixMilli, "", "-1000", time.Unix(-1, 0), ""},
+		{LayoutUnix, "", "2015-03-01T10:00:00", time.Time{}, `invalid epoch date "2015-03-01T10:00:00"`},
+	}
+
+	for _, test := range tests {
+
+		df, err := NewDateFormat(test.layout, test.timeZone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := df.Parse(test.input)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q with layout %q: error is %v, expected %q", test.input, test.layout, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q with layout %q: %v", test.input, test.layout, err)
+			continue
+		}
+
+		if !d.Equal(test.date) {
+			t.Errorf("%q with layout %q: date is %v, expected %v", test.input, test.layout, d, test.date)
+		}
+	}
+}
+
+func TestDateFormatFromEpoch(t *testing.T) {
+
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	df := &DateFormat{Layout: LayoutUnix, Location: oslo}
+	d := df.FromEpoch(1425204000.25)
+
+	if d.Location() != oslo || d.Hour() != 11 {
+		t.Errorf("date is %v, expected 11:00 in Europe/Oslo", d)
+	}
+
+	if d.Nanosecond() != 250000000 {
+		t.Errorf("fraction is %dns, expected 250000000ns", d.Nanosecond())
+	}
+}
@@ -0,0 +1,153 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the writer options a format can support
+const (
+	OptionScientific = "use-scientific"
+	OptionLabels     = "use-labels"
+	OptionChannel    = "use-channel"
+	OptionWaypoints  = "use-waypoints"
+	OptionDatabase   = "use-database"
+	OptionTable      = "use-table"
+	OptionTrack      = "use-track"
+	OptionAltitude   = "use-altitude-mode"
+	OptionColorScale = "use-color-scale"
+	OptionPalette    = "use-palette"
+	OptionClasses    = "use-classes"
+	OptionBinning    = "use-binning"
+	OptionBreaks     = "use-breaks"
+	OptionFolders    = "use-folders"
+)
+
+// ColorScaleOptions Names of the writer options making up a color scale
+var ColorScaleOptions = []string{OptionColorScale, OptionPalette, OptionClasses, OptionBinning, OptionBreaks}
+
+// Format Structure representing a registered output format
+type Format struct {
+	Name         string   // Name used to select the format
+	Description  string   // Short description shown when listing formats
+	Extension    string   // Extension added to the sample file name, including the leading dot
+	Options      []string // Names of the writer options supported by the format
+	SanitizeName bool     // Replace characters Google Earth doesn't like in output file names
+	WritesFile   bool     // The writer creates the OutputFile option itself and is given no stream
+	New          func(w io.Writer, opts WriterOptions) (SampleWriter, error)
+}
+
+var formats = make(map[string]*Format)
+
+// RegisterFormat Make an output format available by name. Registering the same name twice panics
+func RegisterFormat(f Format) {
+
+	if f.New == nil {
+		panic("sampleconverter: RegisterFormat " + f.Name + " without constructor")
+	}
+
+	if _, dup := formats[f.Name]; dup {
+		panic("sampleconverter: RegisterFormat called twice for format " + f.Name)
+	}
+
+	formats[f.Name] = &f
+}
+
+// LookupFormat Get a registered output format by name
+func LookupFormat(name string) (*Format, error) {
+
+	f, ok := formats[name]
+	if !ok {
+		return nil, errors.New("Output format not supported: " + name)
+	}
+
+	return f, nil
+}
+
+// Formats Get all registered output formats sorted by name
+func Formats() []*Format {
+
+	list := make([]*Format, 0, len(formats))
+	for _, f := range formats {
+		list = append(list, f)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// FormatNames Get the names of all registered output formats sorted by name
+func FormatNames() []string {
+
+	var names []string
+	for _, f := range Formats() {
+		names = append(names, f.Name)
+	}
+
+	return names
+}
+
+// Supports Check if the format supports the named writer option
+func (f *Format) Supports(option string) bool {
+
+	for _, o := range f.Options {
+		if o == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultOutputTemplate Output file name template adding the format extension to the sample file name
+const DefaultOutputTemplate = "{name}.{ext}"
+
+// OutputFile Get the output file name for a sample file converted to this format
+func (f *Format) OutputFile(sampleFile string) string {
+
+	return f.OutputFileFromTemplate(DefaultOutputTemplate, sampleFile, "", time.Now())
+}
+
+// OutputFileFromTemplate Get the output file name for a sample file converted to this format with a plugin,
+// in the directory of the sample file. The template can refer to the sample file name as {name},
+// the sample file name without extension as {base}, the plugin name as {plugin}, the date of the
+// conversion as {date}, the format name as {format} and the format extension without the leading dot as {ext}
+func (f *Format) OutputFileFromTemplate(template, sampleFile, plugin string, date time.Time) string {
+
+	name := filepath.Base(sampleFile)
+
+	r := strings.NewReplacer(
+		"{name}", name,
+		"{base}", strings.TrimSuffix(name, filepath.Ext(name)),
+		"{plugin}", plugin,
+		"{date}", date.Format("2006-01-02"),
+		"{format}", f.Name,
+		"{ext}", strings.TrimPrefix(f.Extension, "."))
+
+	outputFile := filepath.Join(filepath.Dir(sampleFile), r.Replace(template))
+	if f.SanitizeName {
+		outputFile = SafeFileName(outputFile)
+	}
+
+	return outputFile
+}
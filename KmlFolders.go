@@ -0,0 +1,147 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+// Groupings of placemarks into kml folders
+const (
+	FoldersFile  = "file"
+	FoldersDay   = "day"
+	FoldersHour  = "hour"
+	FoldersClass = "class"
+)
+
+// Structure representing the grouping of placemarks into kml folders.
+// Folders by file, day and hour are opened when the group of consecutive samples changes,
+// folders by class are written one class at a time from a spool
+type kmlFolders struct {
+	Grouping string
+	Source   string
+	Channel  string
+	Unit     string
+	Mod      byte
+	Classes  *ColorClasses
+	fw       *bufio.Writer
+	open     bool
+	name     string
+}
+
+// Check if a folder grouping is supported. Empty means no folders
+func validFolders(grouping string) error {
+
+	switch grouping {
+	case "", FoldersFile, FoldersDay, FoldersHour, FoldersClass:
+		return nil
+	}
+
+	return errors.New("Folder grouping not supported: " + grouping)
+}
+
+// Get the name of the folder a sample belongs to
+func (f *kmlFolders) folderName(s *Sample) string {
+
+	switch f.Grouping {
+	case FoldersFile:
+		return sampleSource(s, f.Source)
+	case FoldersDay:
+		return s.Date.Format("2006-01-02")
+	case FoldersHour:
+		return s.Date.Format("2006-01-02 15:00")
+	case FoldersClass:
+		m, _ := s.Channel(f.Channel)
+		return f.Classes.LegendLabel(f.Classes.Class(m.Value), f.Unit, f.Mod)
+	}
+
+	return ""
+}
+
+// Write Write a sample with the given placemark writer, opening a new folder if the sample belongs to another group
+func (f *kmlFolders) Write(s *Sample, write func(s *Sample) error) error {
+
+	if len(f.Grouping) > 0 {
+		f.enter(f.folderName(s))
+	}
+
+	return write(s)
+}
+
+// WriteSpool Write all spooled samples with the given placemark writer, grouped in folders
+func (f *kmlFolders) WriteSpool(spool *SampleSpool, write func(s *Sample) error) error {
+
+	if f.Grouping != FoldersClass {
+
+		err := spool.Each(func(s *Sample) error {
+			return f.Write(s, write)
+		})
+		if err != nil {
+			return err
+		}
+
+		f.Close()
+		return nil
+	}
+
+	// One pass for each class, empty classes get no folder
+	for class := range f.Classes.Colors {
+
+		err := spool.Each(func(s *Sample) error {
+			m, _ := s.Channel(f.Channel)
+			if f.Classes.Class(m.Value) != class {
+				return nil
+			}
+			return f.Write(s, write)
+		})
+		if err != nil {
+			return err
+		}
+
+		f.Close()
+	}
+
+	return nil
+}
+
+// Open a folder unless it is already open
+func (f *kmlFolders) enter(name string) {
+
+	if f.open && f.name == name {
+		return
+	}
+
+	f.Close()
+
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(name))
+
+	f.fw.WriteString("    <Folder>\n      <name>" + b.String() + "</name>\n")
+	f.open = true
+	f.name = name
+}
+
+// Close Close the open folder, if any
+func (f *kmlFolders) Close() {
+
+	if f.open {
+		f.fw.WriteString("    </Folder>\n")
+		f.open = false
+	}
+}
@@ -0,0 +1,166 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Layout of the legend image, in pixels
+const (
+	legendMargin  = 8
+	legendRow     = 18
+	legendSwatch  = 14
+	legendSpacing = 6
+)
+
+// ScreenOverlay Structure representing a kml screen overlay
+type ScreenOverlay struct {
+	XMLName xml.Name `xml:"ScreenOverlay"`
+	Name    string   `xml:"name"`
+	Icon    struct {
+		Href string `xml:"href"`
+	}
+	OverlayXY Vec2 `xml:"overlayXY"`
+	ScreenXY  Vec2 `xml:"screenXY"`
+	Size      Vec2 `xml:"size"`
+}
+
+// Vec2 Structure representing a kml screen position or size
+type Vec2 struct {
+	X      string `xml:"x,attr"`
+	Y      string `xml:"y,attr"`
+	XUnits string `xml:"xunits,attr"`
+	YUnits string `xml:"yunits,attr"`
+}
+
+// Get a screen overlay showing the legend in the lower left corner of the screen, in its own size
+func legendOverlay() ScreenOverlay {
+
+	var so ScreenOverlay
+	so.Name = "Legend"
+	so.Icon.Href = "files/legend.png"
+	so.OverlayXY = Vec2{X: "0", Y: "0", XUnits: "fraction", YUnits: "fraction"}
+	so.ScreenXY = Vec2{X: "0.01", Y: "0.05", XUnits: "fraction", YUnits: "fraction"}
+	so.Size = Vec2{X: "0", Y: "0", XUnits: "pixels", YUnits: "pixels"}
+
+	return so
+}
+
+// Write the legend screen overlay to a kml file
+func writeLegendOverlay(fw *bufio.Writer) error {
+
+	b, err := xml.MarshalIndent(legendOverlay(), "    ", "    ")
+	if err != nil {
+		return err
+	}
+	fw.WriteString(string(b) + "\n")
+
+	return nil
+}
+
+// LegendLabel Get the label of a class in a legend. The first and last classes are open ended
+func (cc *ColorClasses) LegendLabel(class int, unit string, mod byte) string {
+
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, mod, -1, 64)
+	}
+
+	label := "All values"
+
+	switch {
+	case len(cc.Breaks) == 0:
+	case class == 0:
+		label = "<= " + format(cc.Breaks[0])
+	case class == len(cc.Breaks):
+		label = "> " + format(cc.Breaks[class-1])
+	default:
+		label = format(cc.Breaks[class-1]) + " - " + format(cc.Breaks[class])
+	}
+
+	if len(unit) > 0 {
+		label += " " + unit
+	}
+
+	return label
+}
+
+// LegendImage Draw a legend with a title and a color swatch and label for each class
+func LegendImage(cc *ColorClasses, title, unit string, mod byte) image.Image {
+
+	face := basicfont.Face7x13
+
+	labels := make([]string, len(cc.Colors))
+	width := font.MeasureString(face, title).Ceil()
+	for i := range labels {
+		labels[i] = cc.LegendLabel(i, unit, mod)
+		w := legendSwatch + legendSpacing + font.MeasureString(face, labels[i]).Ceil()
+		if w > width {
+			width = w
+		}
+	}
+
+	width += legendMargin * 2
+	height := legendMargin*2 + legendRow*(len(labels)+1)
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{255, 255, 255, 220}), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: img, Src: image.Black, Face: face}
+
+	// Text is drawn on the baseline, centered vertically in the row
+	baseline := (legendRow + face.Ascent - face.Descent) / 2
+
+	d.Dot = fixed.P(legendMargin, legendMargin+baseline)
+	d.DrawString(title)
+
+	for i, label := range labels {
+
+		y := legendMargin + legendRow*(i+1)
+
+		c, _ := parseKmlColor(cc.Colors[i])
+		swatch := image.Rect(legendMargin, y+(legendRow-legendSwatch)/2, legendMargin+legendSwatch, y+(legendRow+legendSwatch)/2)
+		draw.Draw(img, swatch, image.Black, image.Point{}, draw.Src)
+		draw.Draw(img, swatch.Inset(1), image.NewUniform(color.NRGBA{c[3], c[2], c[1], c[0]}), image.Point{}, draw.Src)
+
+		d.Dot = fixed.P(legendMargin+legendSwatch+legendSpacing, y+baseline)
+		d.DrawString(label)
+	}
+
+	return img
+}
+
+// Add a legend image to a kmz archive
+func addLegend(zw *zip.Writer, cc *ColorClasses, title, unit string, mod byte) error {
+
+	z, err := zw.Create("files/legend.png")
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(z, LegendImage(cc, title, unit, mod))
+}
@@ -0,0 +1,264 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExpectedSuffix Suffix of the file next to a fixture file holding the samples expected from it
+const ExpectedSuffix = ".expected.json"
+
+// ExpectedSample Structure representing a sample a plugin is expected to read from a line of a fixture file.
+// Line is 0 for samples of the begin hook and EndLine for samples of the end hook.
+// Fields holds the sample fields to compare, as they are written by the json format
+type ExpectedSample struct {
+	Line   int
+	Fields map[string]interface{}
+}
+
+// PluginCheck Structure representing the result of checking a plugin against a fixture file
+type PluginCheck struct {
+	Samples  int          // Number of samples read
+	Expected int          // Number of samples expected, zero without an expected file
+	Problems []*LineError // Lines the plugin failed to parse and samples differing from the expected
+}
+
+// Sample readers able to tell the line the last sample read was made from
+type lineNumberReader interface {
+	LineNumber() int
+}
+
+// ExpectedFile Get the name of the expected samples file of a fixture file
+func ExpectedFile(fixtureFile string) string {
+
+	return strings.TrimSuffix(fixtureFile, filepath.Ext(fixtureFile)) + ExpectedSuffix
+}
+
+// LoadExpectedSamples Load a json array of expected samples. Each sample has a "line" field
+// with the line number in the fixture file, 0 for the begin hook or "end" for the end hook,
+// and the sample fields to compare
+func LoadExpectedSamples(fileName string) ([]ExpectedSample, error) {
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []map[string]interface{}
+	err = json.Unmarshal(b, &objs)
+	if err != nil {
+		return nil, errors.New(fileName + ": " + err.Error())
+	}
+
+	expected := make([]ExpectedSample, len(objs))
+	for i, obj := range objs {
+		line, ok := obj["line"].(float64)
+		if !ok || line < 0 || line != math.Trunc(line) {
+			if obj["line"] != "end" {
+				return nil, fmt.Errorf("%s: sample %d has no valid line number", fileName, i+1)
+			}
+			line = EndLine
+		}
+
+		delete(obj, "line")
+		expected[i] = ExpectedSample{Line: int(line), Fields: obj}
+	}
+
+	return expected, nil
+}
+
+// CheckPlugin Read a fixture file with a plugin, through the same sample reader used for conversions,
+// and compare the samples to the expected samples of the fixture, if it has an expected file.
+// The SampleFile option is set to the fixture file
+func CheckPlugin(pluginFile, fixtureFile string, opts ReaderOptions) (*PluginCheck, error) {
+
+	var expected []ExpectedSample
+
+	expectedFile := ExpectedFile(fixtureFile)
+	if _, err := os.Stat(expectedFile); err == nil {
+		expected, err = LoadExpectedSamples(expectedFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := ioutil.ReadFile(fixtureFile)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(b), "\r\n"), "\n")
+
+	opts.SampleFile = fixtureFile
+	sr, err := NewSampleReader(pluginFile, bytes.NewReader(b), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer sr.Close()
+
+	lr, ok := sr.(lineNumberReader)
+	if !ok {
+		return nil, errors.New("Plugin type can not be checked: " + pluginFile)
+	}
+
+	// Expected samples are matched in order on each line
+	pending := make(map[int][]ExpectedSample)
+	for _, e := range expected {
+		pending[e.Line] = append(pending[e.Line], e)
+	}
+
+	check := &PluginCheck{Expected: len(expected)}
+
+	problem := func(line int, err error) {
+
+		text := ""
+		if line > 0 && line <= len(lines) {
+			text = strings.TrimRight(lines[line-1], "\r")
+		}
+		check.Problems = append(check.Problems, &LineError{PluginFile: pluginFile, Line: line, Text: text, Err: err})
+	}
+
+	for {
+		s, more, err := sr.Read()
+		if err != nil {
+			le, ok := err.(*LineError)
+			if !ok {
+				return nil, err
+			}
+			check.Problems = append(check.Problems, le)
+			continue
+		}
+
+		if !more {
+			break
+		}
+
+		check.Samples++
+		line := lr.LineNumber()
+
+		if expected == nil {
+			continue
+		}
+
+		if len(pending[line]) == 0 {
+			problem(line, errors.New("unexpected sample"))
+			continue
+		}
+
+		e := pending[line][0]
+		pending[line] = pending[line][1:]
+
+		for _, err := range compareSample(s, e.Fields) {
+			problem(line, err)
+		}
+	}
+
+	// Report expected samples never read, in line order with the samples of the end hook last
+	var missing []int
+	for line, es := range pending {
+		for range es {
+			missing = append(missing, line)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[j] == EndLine {
+			return missing[i] != EndLine
+		}
+		return missing[i] != EndLine && missing[i] < missing[j]
+	})
+
+	for _, line := range missing {
+		problem(line, errors.New("expected sample not read"))
+	}
+
+	return check, nil
+}
+
+// Compare a sample to the expected fields, returning an error for each field that differs
+func compareSample(s *Sample, fields map[string]interface{}) []error {
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return []error{err}
+	}
+
+	var actual map[string]interface{}
+	err = json.Unmarshal(b, &actual)
+	if err != nil {
+		return []error{err}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+
+	for _, name := range names {
+		want := fields[name]
+
+		got, ok := actual[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is missing, expected %s", name, jsonText(want)))
+			continue
+		}
+
+		if !equalField(name, got, want) {
+			errs = append(errs, fmt.Errorf("%s is %s, expected %s", name, jsonText(got), jsonText(want)))
+		}
+	}
+
+	return errs
+}
+
+// Check if a sample field equals the expected value. Dates are equal if they are the same instant
+func equalField(name string, got, want interface{}) bool {
+
+	if name == "date" {
+		g, gerr := time.Parse(time.RFC3339Nano, fmt.Sprint(got))
+		w, werr := time.Parse(time.RFC3339Nano, fmt.Sprint(want))
+		if gerr == nil && werr == nil {
+			return g.Equal(w)
+		}
+	}
+
+	return reflect.DeepEqual(got, want)
+}
+
+// Format a value as json for display
+func jsonText(v interface{}) string {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
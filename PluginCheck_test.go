@@ -0,0 +1,137 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Plugin making samples in the begin and end hooks, and both emitting and returning samples for each line
+const checkTestPlugin = `
+function sample(v) { return { date: "2015-03-01T10:00:00", latitude: 1, longitude: 2, altitude: 3, value: v, unit: "uSv/h" }; }
+function begin(fileName, fileAttributes) { emit(sample(0.5)); }
+function parseLine(n, line) { var v = parseFloat(line); emit(sample(v)); return [sample(v * 2)]; }
+function end() { return [sample(9)]; }
+`
+
+// Write a fixture file and its expected samples file to a temporary directory
+func writeTestFixture(t *testing.T, fixture, expected string) string {
+
+	dir := t.TempDir()
+	fixtureFile := filepath.Join(dir, "fixture.log")
+
+	err := ioutil.WriteFile(fixtureFile, []byte(fixture), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(ExpectedFile(fixtureFile), []byte(expected), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fixtureFile
+}
+
+func TestCheckPluginHookLines(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		expected string
+		problems []int // Lines with problems
+	}{
+		{"all samples", `[{"line": 0, "value": 0.5}, {"line": 1, "value": 1}, {"line": 1, "value": 2},
+			{"line": 2, "value": 2}, {"line": 2, "value": 4}, {"line": "end", "value": 9}]`, nil},
+		{"wrong values", `[{"line": 0, "value": 0.6}, {"line": 1, "value": 1}, {"line": 1, "value": 2},
+			{"line": 2, "value": 2}, {"line": 2, "value": 4}, {"line": "end", "value": 8}]`, []int{0, EndLine}},
+		{"samples not read", `[{"line": 0, "value": 0.5}, {"line": 1, "value": 1}, {"line": 1, "value": 2},
+			{"line": 2, "value": 2}, {"line": 2, "value": 4}, {"line": "end", "value": 9}, {"line": "end", "value": 10},
+			{"line": 3, "value": 3}]`, []int{3, EndLine}},
+		{"samples not expected", `[{"line": 1, "value": 1}, {"line": 1, "value": 2},
+			{"line": 2, "value": 2}, {"line": 2, "value": 4}]`, []int{0, EndLine}},
+	}
+
+	pluginFile := writeTestPlugin(t, checkTestPlugin)
+
+	for _, test := range tests {
+
+		check, err := CheckPlugin(pluginFile, writeTestFixture(t, "1\n2\n", test.expected), ReaderOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if check.Samples != 6 {
+			t.Errorf("%s: %d samples read, expected 6", test.name, check.Samples)
+		}
+
+		var lines []int
+		for _, p := range check.Problems {
+			lines = append(lines, p.Line)
+		}
+
+		if !reflect.DeepEqual(lines, test.problems) {
+			t.Errorf("%s: problems on lines %v, expected %v", test.name, lines, test.problems)
+		}
+	}
+}
+
+func TestLoadExpectedSamples(t *testing.T) {
+
+	tests := []struct {
+		expected string
+		lines    []int
+		valid    bool
+	}{
+		{`[{"line": 0}, {"line": 4}, {"line": "end"}]`, []int{0, 4, EndLine}, true},
+		{`[{"value": 1}]`, nil, false},
+		{`[{"line": -1}]`, nil, false},
+		{`[{"line": 1.5}]`, nil, false},
+		{`[{"line": "begin"}]`, nil, false},
+	}
+
+	for _, test := range tests {
+
+		fileName := filepath.Join(t.TempDir(), "fixture"+ExpectedSuffix)
+		err := ioutil.WriteFile(fileName, []byte(test.expected), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := LoadExpectedSamples(fileName)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: loaded, expected an error", test.expected)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.expected, err)
+		}
+
+		var lines []int
+		for _, e := range expected {
+			lines = append(lines, e.Line)
+		}
+
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: lines are %v, expected %v", test.expected, lines, test.lines)
+		}
+	}
+}
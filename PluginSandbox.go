@@ -0,0 +1,157 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// Global objects removed from the plugin runtime. Plugins parse lines of text and have no need to compile code
+var pluginRestrictedGlobals = []string{"eval", "Function"}
+
+// Panic value used to interrupt a plugin
+var errPluginInterrupt = errors.New("Plugin interrupted")
+
+// Error returned when a plugin has used up the time budget of the sample file
+var errPluginFileTimeout = errors.New("Plugin time budget for the sample file used up")
+
+// Create a plugin runtime with the restricted globals removed and the console writing to stderr,
+// so plugin messages can not mix with output written to stdout
+func newPluginRuntime(pluginFile string) (*otto.Otto, error) {
+
+	vm := otto.New()
+
+	// Every function reaches the Function constructor through its prototype, e.g. (function(){}).constructor,
+	// so it is removed there before the global is
+	_, err := vm.Run(`Object.defineProperty(Function.prototype, "constructor", { value: undefined })`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range pluginRestrictedGlobals {
+		err = vm.Set(name, otto.UndefinedValue())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	console, err := vm.Object("({})")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(pluginFile) + ": "
+	log := func(call otto.FunctionCall) otto.Value {
+
+		args := make([]string, len(call.ArgumentList))
+		for i, arg := range call.ArgumentList {
+			args[i] = arg.String()
+		}
+		fmt.Fprintln(os.Stderr, prefix+strings.Join(args, " "))
+
+		return otto.UndefinedValue()
+	}
+
+	for _, name := range []string{"log", "info", "warn", "error", "debug"} {
+		err = console.Set(name, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = vm.Set("console", console)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// Run a function calling into the plugin, interrupting the plugin when the time budget
+// of the line or of the sample file runs out. Without budgets the function is just called
+func (sr *SampleReaderJS) limit(f func() error) (err error) {
+
+	budget := sr.opts.LineTimeout
+	fileBudget := false
+
+	if sr.opts.FileTimeout > 0 {
+		left := sr.opts.FileTimeout - sr.elapsed
+		if left <= 0 {
+			return errPluginFileTimeout
+		}
+
+		if budget <= 0 || left < budget {
+			budget = left
+			fileBudget = true
+		}
+	}
+
+	if budget <= 0 {
+		return f()
+	}
+
+	// The interrupt channel is replaced for each call, so a late timer can't interrupt the next call.
+	// otto runs the interrupt function between statements, and its panic is not a javascript exception.
+	// But a try statement recovers any panic, fails to convert the Go value to javascript and panics
+	// again with a TypeError, which an enclosing try statement catches like any exception. So the
+	// interrupt is sent again each time it runs, until the plugin has returned
+	interrupt := make(chan func(), 1)
+	sr.vm.Interrupt = interrupt
+
+	// The panic can come out of the plugin as that TypeError, so a flag tells if the plugin was interrupted
+	interrupted := false
+
+	var stop func()
+	stop = func() {
+		interrupted = true
+		interrupt <- stop
+		panic(errPluginInterrupt)
+	}
+
+	timer := time.AfterFunc(budget, func() {
+		interrupt <- stop
+	})
+
+	start := time.Now()
+
+	defer func() {
+
+		timer.Stop()
+		sr.vm.Interrupt = nil
+		sr.elapsed += time.Since(start)
+
+		if caught := recover(); caught != nil && caught != errPluginInterrupt {
+			panic(caught)
+		}
+
+		if interrupted {
+			if fileBudget {
+				err = errPluginFileTimeout
+			} else {
+				err = errors.New("plugin did not finish within " + budget.String())
+			}
+		}
+	}()
+
+	return f()
+}
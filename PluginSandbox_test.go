@@ -0,0 +1,124 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a javascript plugin to a temporary directory
+func writeTestPlugin(t *testing.T, source string) string {
+
+	pluginFile := filepath.Join(t.TempDir(), "plugin.js")
+
+	err := ioutil.WriteFile(pluginFile, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pluginFile
+}
+
+func TestPluginTimeout(t *testing.T) {
+
+	const sample = `date = "2015-03-01T10:00:00"; latitude = 1; longitude = 2; altitude = 3; value = 4; unit = "uSv/h";`
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"loop", `function parseLine(n, line) { while (true) {} }`},
+		{"loop in try", `function parseLine(n, line) { try { while (true) {} } catch (e) {} ` + sample + ` return true; }`},
+		{"loop around try", `function parseLine(n, line) { while (true) { try { while (true) {} } catch (e) {} } }`},
+		{"loop around nested try", `function parseLine(n, line) { while (true) { try { try { while (true) {} } catch (e) {} } catch (e) {} } }`},
+		{"loop in finally", `function parseLine(n, line) { try { while (true) {} } finally { while (true) {} } }`},
+	}
+
+	for _, test := range tests {
+
+		sr, err := NewSampleReader(writeTestPlugin(t, test.source), strings.NewReader("line\n"), ReaderOptions{LineTimeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := sr.Read()
+			done <- err
+		}()
+
+		select {
+		case err = <-done:
+			if err == nil || !strings.Contains(err.Error(), "did not finish within 50ms") {
+				t.Errorf("%s: error is %v, expected the plugin to be stopped", test.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: plugin was not stopped", test.name)
+		}
+
+		// The runtime can be used again after an interrupt
+		_, more, err := sr.Read()
+		if err != nil || more {
+			t.Errorf("%s: reading after the interrupt gave %v, %v, expected the end of the file", test.name, more, err)
+		}
+	}
+}
+
+func TestPluginFileTimeout(t *testing.T) {
+
+	source := `function parseLine(n, line) { var end = Date.now() + 30; while (Date.now() < end) {} return false; }`
+	lines := strings.Repeat("line\n", 100)
+
+	sr, err := NewSampleReader(writeTestPlugin(t, source), strings.NewReader(lines), ReaderOptions{FileTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = sr.Read()
+	if err == nil || !strings.Contains(err.Error(), "did not finish the sample file within 100ms") {
+		t.Errorf("error is %v, expected the plugin to be stopped", err)
+	}
+}
+
+func TestPluginRestrictedGlobals(t *testing.T) {
+
+	tests := []string{
+		`eval("1")`,
+		`Function("return 1")()`,
+		`(function(){}).constructor("return 1")()`,
+		`[].constructor.constructor("return 1")()`,
+		`Object.getPrototypeOf(function(){}).constructor("return 1")()`,
+	}
+
+	for _, code := range tests {
+
+		source := `function parseLine(n, line) { ` + code + `; return false; }`
+
+		sr, err := NewSampleReader(writeTestPlugin(t, source), strings.NewReader("line\n"), ReaderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = sr.Read()
+		if _, ok := err.(*LineError); !ok {
+			t.Errorf("%s: error is %v, expected a line error", code, err)
+		}
+	}
+}
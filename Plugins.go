@@ -0,0 +1,87 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PluginExtensions File extensions recognized as plugins, in order of precedence
+var PluginExtensions = []string{".js", ".json"}
+
+// ListPlugins Get the names of all plugins in a plugin directory
+func ListPlugins(dir string) ([]string, error) {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if f.IsDir() || !isPluginExtension(ext) {
+			continue
+		}
+
+		// Expected samples of fixtures kept in the plugin directory are not plugins
+		if strings.HasSuffix(strings.ToLower(f.Name()), ExpectedSuffix) {
+			continue
+		}
+
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// FindPlugin Get the plugin file for a named plugin in a plugin directory
+func FindPlugin(dir, name string) (string, error) {
+
+	for _, ext := range PluginExtensions {
+		pluginFile := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(pluginFile); err == nil {
+			return pluginFile, nil
+		}
+	}
+
+	return "", errors.New("Plugin " + name + " does not exist in " + dir)
+}
+
+// Check if a file extension is a plugin extension
+func isPluginExtension(ext string) bool {
+
+	for _, e := range PluginExtensions {
+		if e == ext {
+			return true
+		}
+	}
+
+	return false
+}
@@ -13,20 +13,211 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
+	"math"
+	"strconv"
 	"time"
 )
 
 // Sample Structure representing a sample
 type Sample struct {
-	XMLName   xml.Name  `xml:"sample" json:"-"`
-	Date      time.Time `xml:"date" json:"date"`
-	Latitude  float64   `xml:"latitude" json:"latitude"`
-	Longitude float64   `xml:"longitude" json:"longitude"`
-	Altitude  float64   `xml:"altitude" json:"altitude"`
-	Value     float64   `xml:"value" json:"value"`
-	Unit      string    `xml:"unit" json:"unit"`
+	XMLName      xml.Name     `xml:"sample" json:"-"`
+	Date         time.Time    `xml:"date" json:"date"`
+	Latitude     float64      `xml:"latitude" json:"latitude"`
+	Longitude    float64      `xml:"longitude" json:"longitude"`
+	Altitude     float64      `xml:"altitude" json:"altitude"`
+	Value        float64      `xml:"value" json:"value"`
+	Unit         string       `xml:"unit" json:"unit"`
+	Uncertainty  *Uncertainty `xml:"uncertainty,omitempty" json:"uncertainty,omitempty"`
+	Measurements Measurements `xml:"measurements,omitempty" json:"measurements,omitempty"`
+	Attributes   Attributes   `xml:"attributes,omitempty" json:"attributes,omitempty"`
+}
+
+// Measurement Structure representing a named measured quantity of a sample
+type Measurement struct {
+	Name        string       `xml:"name,attr" json:"name"`
+	Value       float64      `xml:"value" json:"value"`
+	Unit        string       `xml:"unit" json:"unit"`
+	Uncertainty *Uncertainty `xml:"uncertainty,omitempty" json:"uncertainty,omitempty"`
+}
+
+// Uncertainty Structure representing the uncertainty of a measured value
+type Uncertainty struct {
+	Value    float64 `xml:",chardata" json:"value"`
+	Relative bool    `xml:"relative,attr" json:"relative"` // The value is a fraction of the measured value
+	Coverage float64 `xml:"coverage,attr" json:"coverage"` // The coverage factor k
+}
+
+// Absolute Get the absolute uncertainty of a measured value
+func (u *Uncertainty) Absolute(value float64) float64 {
+
+	if u.Relative {
+		return math.Abs(u.Value * value)
+	}
+
+	return u.Value
+}
+
+// Format Format the uncertainty for display, e.g. "± 0.05 uSv/h (k=2)" or "± 5% (k=2)"
+func (u *Uncertainty) Format(unit string, mod byte) string {
+
+	k := " (k=" + strconv.FormatFloat(u.Coverage, 'f', -1, 64) + ")"
+
+	if u.Relative {
+		return "± " + strconv.FormatFloat(u.Value*100, 'f', -1, 64) + "%" + k
+	}
+
+	return "± " + strconv.FormatFloat(u.Value, mod, -1, 64) + " " + unit + k
+}
+
+// PrimaryChannel Name of the channel holding the primary value and unit of a sample
+const PrimaryChannel = "value"
+
+// Channel Get a named measurement of the sample. An empty name or PrimaryChannel selects the primary value and unit
+func (s *Sample) Channel(name string) (Measurement, bool) {
+
+	if len(name) == 0 || name == PrimaryChannel {
+		return Measurement{Name: PrimaryChannel, Value: s.Value, Unit: s.Unit, Uncertainty: s.Uncertainty}, true
+	}
+
+	for _, m := range s.Measurements {
+		if m.Name == name {
+			return m, true
+		}
+	}
+
+	return Measurement{}, false
+}
+
+// ChannelNames Get the names of the measurements of the sample
+func (s *Sample) ChannelNames() []string {
+
+	names := make([]string, len(s.Measurements))
+	for i, m := range s.Measurements {
+		names[i] = m.Name
+	}
+
+	return names
+}
+
+// Measurements List of the measurement channels of a sample
+type Measurements []Measurement
+
+// MarshalXML Write the measurements as a list of measurement elements. Samples without channels
+// have no measurements element, as empty lists are left out by omitempty
+func (m Measurements) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+
+	return e.EncodeElement(struct {
+		Measurement []Measurement `xml:"measurement"`
+	}{m}, start)
+}
+
+// Attribute Structure representing an extra named attribute of a sample.
+// The value is a string, a float64 or a bool
+type Attribute struct {
+	Name  string
+	Value interface{}
+}
+
+// Attributes Ordered list of extra sample attributes
+type Attributes []Attribute
+
+// Get Get the value of a named attribute
+func (a Attributes) Get(name string) (interface{}, bool) {
+
+	for _, attr := range a {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Names Get the names of all attributes
+func (a Attributes) Names() []string {
+
+	names := make([]string, len(a))
+	for i, attr := range a {
+		names[i] = attr.Name
+	}
+
+	return names
+}
+
+// MarshalJSON Write the attributes as a JSON object, keeping their order
+func (a Attributes) MarshalJSON() ([]byte, error) {
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, attr := range a {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(attr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// MarshalXML Write the attributes as a list of attribute elements
+func (a Attributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range a {
+		elem := xml.StartElement{
+			Name: xml.Name{Local: "attribute"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: attr.Name}},
+		}
+
+		err = e.EncodeElement(FormatAttributeValue(attr.Value), elem)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+// FormatAttributeValue Format an attribute value as a string
+func FormatAttributeValue(v interface{}) string {
+
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
 }
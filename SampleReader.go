@@ -13,296 +13,55 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
-	"bufio"
 	"errors"
-	"github.com/robertkrimen/otto"
-	"io/ioutil"
-	"os"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-// SampleReader Structure representing a sample reader
-type SampleReader struct {
-	pluginFile string
-	sampleFile string
-	MinValue   float64
-	MaxValue   float64
-	fd         *os.File
-	scanner    *bufio.Scanner
-	lineNum    int
-	vm         *otto.Otto
+// SampleReader Common interface for sample readers
+type SampleReader interface {
+	Read() (*Sample, bool, error)
+	Close() error
 }
 
-// NewSampleReader Create a new sample reader
-func NewSampleReader(pluginFile, sampleFile string) (*SampleReader, error) {
-
-	// Initialize a sample reader structure
-	sr := new(SampleReader)
-	sr.pluginFile = pluginFile
-	sr.sampleFile = sampleFile
-	sr.MinValue = 0.0
-	sr.MaxValue = 0.0
-
-	var err error
-
-	sr.fd, err = os.Open(sr.sampleFile)
-	if err != nil {
-		return nil, err
-	}
-
-	sr.scanner = bufio.NewScanner(sr.fd)
-	sr.lineNum = 0
-
-	// Create a otto javascript runtime
-	sr.vm, err = sr.createPluginRuntime()
-	if err != nil {
-		return nil, err
-	}
-
-	// Scan the sample file to find the min and max measurement values (God, make it stop)
-	// The kmz sample writer need these values to calculate the correct placemark colors
-	initialized := false
-
-	for sr.scanner.Scan() {
-
-		sr.lineNum++
-		samp, err := sr.execPlugin(sr.scanner.Text(), sr.lineNum)
-		if err != nil {
-			return nil, err
-		}
-
-		if samp == nil {
-			continue
-		}
-
-		if !initialized {
-			initialized = true
-			sr.MinValue = samp.Value
-			sr.MaxValue = samp.Value
-		} else {
-			if samp.Value < sr.MinValue {
-				sr.MinValue = samp.Value
-			}
-			if samp.Value > sr.MaxValue {
-				sr.MaxValue = samp.Value
-			}
-		}
-	}
-
-	err = sr.scanner.Err()
-	if err != nil {
-		return nil, err
-	}
-
-	// Reset the scanner for later use
-	sr.fd.Seek(0, 0)
-	sr.scanner = bufio.NewScanner(sr.fd)
-	sr.lineNum = 0
-
-	return sr, nil
+// LineError Structure representing a sample line a plugin failed to parse.
+// Sample readers can go on reading the next line after returning a line error
+type LineError struct {
+	PluginFile string // Plugin parsing the line
+	Line       int    // Line number in the sample file, starting with 1
+	Text       string // Text of the line
+	Err        error  // Reason the line was rejected
 }
 
-// Read the next line from the sample file using a javascript plugin and make a sample structure from it
-func (sr *SampleReader) Read() (*Sample, bool, error) {
-
-	for {
-		b := sr.scanner.Scan()
-		if !b {
-			break
-		}
-
-		err := sr.scanner.Err()
-		if err != nil {
-			return nil, false, err
-		}
-
-		sr.lineNum++
-
-		sample, err := sr.execPlugin(sr.scanner.Text(), sr.lineNum)
-		if err != nil {
-			return nil, false, err
-		}
-
-		if sample == nil {
-			continue
-		}
+// Error Get the error message, including the plugin and line number
+func (e *LineError) Error() string {
 
-		return sample, true, nil
-	}
-
-	return nil, false, nil
+	return fmt.Sprintf("%s line %d: %v", e.PluginFile, e.Line, e.Err)
 }
 
-// Close the sample reader and clean up
-func (sr *SampleReader) Close() error {
-
-	sr.fd.Close()
-	return nil
+// ReaderOptions Structure representing the options passed to sample readers
+type ReaderOptions struct {
+	SampleFile  string        // Name of the sample file, passed to javascript plugins. "-" for stdin, empty if unknown
+	LineTimeout time.Duration // Time a javascript plugin may spend loading or parsing a single line. Zero means no limit
+	FileTimeout time.Duration // Time a javascript plugin may spend parsing all lines of the sample file. Zero means no limit
 }
 
-// Create a javascript runtime
-func (sr *SampleReader) createPluginRuntime() (*otto.Otto, error) {
-
-	// Read plugin file
-	b, err := ioutil.ReadFile(sr.pluginFile)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create runtime and load plugin file
-	vm := otto.New()
-	_, err = vm.Run(string(b))
-	if err != nil {
-		return nil, err
-	}
-
-	return vm, nil
-}
-
-// Execute plugin and extract a sample
-func (sr *SampleReader) execPlugin(line string, lineNum int) (*Sample, error) {
-
-	// Prepare arguments
-	argLineNum, err := sr.vm.ToValue(lineNum)
-	if err != nil {
-		return nil, err
-	}
-
-	argLine, err := sr.vm.ToValue(line)
-	if err != nil {
-		return nil, err
-	}
-
-	// Execute plugin
-	retVal, err := sr.vm.Call("parseLine", nil, argLineNum, argLine)
-	if err != nil {
-		return nil, err
-	}
-
-	// Extract and evaluate return value
-	ret, err := retVal.ToBoolean()
-	if err != nil {
-		return nil, err
-	}
-
-	if !ret {
-		return nil, nil
-	}
-
-	// Extract a full sample from javascript runtime
-	sample, err := sr.getSample()
-	if err != nil {
-		return nil, err
-	}
-
-	return sample, nil
-}
-
-// Helper function to populate a sample structure with a single sample
-func (sr *SampleReader) getSample() (*Sample, error) {
-
-	var err error
-	var v otto.Value
-
-	s := new(Sample)
-
-	// Extract date field from javascript runtime
-	v, err = sr.vm.Get("date")
-	if err != nil {
-		return nil, err
-	}
-
-	if !v.IsDefined() {
-		return nil, errors.New("date not defined")
-	}
-
-	ds, err := v.ToString()
-	if err != nil {
-		return nil, err
-	}
-
-	s.Date, err = time.Parse("2006-01-02T15:04:05", ds)
-	if err != nil {
-		return nil, err
-	}
-
-	// Extract latitude field from javascript runtime
-	v, err = sr.vm.Get("latitude")
-	if err != nil {
-		return nil, err
-	}
-
-	if !v.IsDefined() {
-		return nil, errors.New("latitude not defined")
-	}
-
-	s.Latitude, err = v.ToFloat()
-	if err != nil {
-		return nil, err
-	}
-
-	// Extract longitude field from javascript runtime
-	v, err = sr.vm.Get("longitude")
-	if err != nil {
-		return nil, err
-	}
-
-	if !v.IsDefined() {
-		return nil, errors.New("longitude not defined")
-	}
-
-	s.Longitude, err = v.ToFloat()
-	if err != nil {
-		return nil, err
-	}
-
-	// Extract altitude field from javascript runtime
-	v, err = sr.vm.Get("altitude")
-	if err != nil {
-		return nil, err
-	}
-
-	if !v.IsDefined() {
-		return nil, errors.New("altitude not defined")
-	}
-
-	s.Altitude, err = v.ToFloat()
-	if err != nil {
-		return nil, err
-	}
-
-	// Extract value field from javascript runtime
-	v, err = sr.vm.Get("value")
-	if err != nil {
-		return nil, err
-	}
-
-	if !v.IsDefined() {
-		return nil, errors.New("value not defined")
-	}
-
-	s.Value, err = v.ToFloat()
-	if err != nil {
-		return nil, err
-	}
-
-	// Extract unit field from javascript runtime
-	v, err = sr.vm.Get("unit")
-	if err != nil {
-		return nil, err
-	}
-
-	if !v.IsDefined() {
-		return nil, errors.New("unit not defined")
-	}
+// NewSampleReader Create a sample reader for the given plugin reading sample lines from r.
+// Javascript plugins (*.js) and parser definitions (*.json) are supported
+func NewSampleReader(pluginFile string, r io.Reader, opts ReaderOptions) (SampleReader, error) {
 
-	s.Unit, err = v.ToString()
-	if err != nil {
-		return nil, err
+	switch strings.ToLower(filepath.Ext(pluginFile)) {
+	case ".js":
+		return NewSampleReaderJS(pluginFile, r, opts)
+	case ".json":
+		return NewSampleReaderDelimited(pluginFile, r)
 	}
 
-	return s, nil
+	return nil, errors.New("Plugin type not supported: " + pluginFile)
 }
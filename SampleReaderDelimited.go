@@ -0,0 +1,533 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sample fields that can be mapped to columns in a parser definition
+var definitionFields = []string{"date", "latitude", "longitude", "altitude", "value", "unit"}
+
+// ParserDefinition Structure representing a declarative plugin for delimited sample files
+type ParserDefinition struct {
+	Delimiter    string                       `json:"delimiter"`    // Field delimiter. Empty splits on whitespace
+	SkipLines    int                          `json:"skipLines"`    // Number of lines to skip at the start of the file
+	Header       bool                         `json:"header"`       // The first line after the skipped lines holds the column names
+	Comment      string                       `json:"comment"`      // Lines starting with this prefix are skipped
+	Columns      map[string]Column            `json:"columns"`      // Columns holding the sample fields
+	DateLayout   string                       `json:"dateLayout"`   // Layout of the date column, using the Go reference time, "unix" or "unixmilli"
+	TimeZone     string                       `json:"timeZone"`     // IANA time zone of dates without an offset. Defaults to UTC
+	Unit         string                       `json:"unit"`         // Unit of the values, used when there is no unit column
+	Attributes   map[string]Column            `json:"attributes"`   // Columns holding extra sample attributes, by attribute name
+	Measurements map[string]MeasurementColumn `json:"measurements"` // Columns holding extra measurements, by channel name
+	Uncertainty  *UncertaintyColumn           `json:"uncertainty"`  // Column holding the uncertainty of the values
+}
+
+// MeasurementColumn Structure representing a column holding a measurement channel in a parser definition
+type MeasurementColumn struct {
+	Column      Column             `json:"column"`
+	Unit        string             `json:"unit"`
+	Uncertainty *UncertaintyColumn `json:"uncertainty"`
+}
+
+// UncertaintyColumn Structure representing a column holding uncertainties in a parser definition
+type UncertaintyColumn struct {
+	Column   Column  `json:"column"`
+	Relative bool    `json:"relative"`
+	Coverage float64 `json:"coverage"`
+}
+
+// Column Structure representing a column in a parser definition,
+// either a zero based index or a column name from the header line
+type Column struct {
+	Index int
+	Name  string
+}
+
+// UnmarshalJSON Read a column from a JSON number or string
+func (c *Column) UnmarshalJSON(b []byte) error {
+
+	if len(b) > 0 && b[0] == '"' {
+		c.Index = -1
+		return json.Unmarshal(b, &c.Name)
+	}
+
+	return json.Unmarshal(b, &c.Index)
+}
+
+// SampleReaderDelimited Structure representing a sample reader using a parser definition
+type SampleReaderDelimited struct {
+	pluginFile string
+	def        ParserDefinition
+	columns    map[string]int
+	attrNames  []string
+	attrCols   []int
+	chanNames  []string
+	chanCols   []int
+	uncCols    map[*UncertaintyColumn]int
+	dateFormat *DateFormat
+	scanner    *bufio.Scanner
+	lineNum    int
+}
+
+// LoadParserDefinition Read and validate a parser definition file
+func LoadParserDefinition(pluginFile string) (*ParserDefinition, error) {
+
+	b, err := ioutil.ReadFile(pluginFile)
+	if err != nil {
+		return nil, err
+	}
+
+	def := new(ParserDefinition)
+
+	err = json.Unmarshal(b, def)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pluginFile, err)
+	}
+
+	if utf8.RuneCountInString(def.Delimiter) > 1 {
+		return nil, fmt.Errorf("%s: the delimiter must be a single character", pluginFile)
+	}
+
+	for field, col := range def.Columns {
+		if !isDefinitionField(field) {
+			return nil, fmt.Errorf("%s: unknown field %s in columns", pluginFile, field)
+		}
+		if len(col.Name) > 0 && !def.Header {
+			return nil, fmt.Errorf("%s: column %s is referenced by name, but header is not set", pluginFile, col.Name)
+		}
+		if len(col.Name) == 0 && col.Index < 0 {
+			return nil, fmt.Errorf("%s: invalid column index for field %s", pluginFile, field)
+		}
+	}
+
+	for name, col := range def.Attributes {
+		if len(col.Name) > 0 && !def.Header {
+			return nil, fmt.Errorf("%s: column %s is referenced by name, but header is not set", pluginFile, col.Name)
+		}
+		if len(col.Name) == 0 && col.Index < 0 {
+			return nil, fmt.Errorf("%s: invalid column index for attribute %s", pluginFile, name)
+		}
+	}
+
+	for name, mc := range def.Measurements {
+		if len(mc.Column.Name) > 0 && !def.Header {
+			return nil, fmt.Errorf("%s: column %s is referenced by name, but header is not set", pluginFile, mc.Column.Name)
+		}
+		if len(mc.Column.Name) == 0 && mc.Column.Index < 0 {
+			return nil, fmt.Errorf("%s: invalid column index for measurement %s", pluginFile, name)
+		}
+	}
+
+	for _, uc := range def.uncertaintyColumns() {
+		if len(uc.Column.Name) > 0 && !def.Header {
+			return nil, fmt.Errorf("%s: column %s is referenced by name, but header is not set", pluginFile, uc.Column.Name)
+		}
+		if len(uc.Column.Name) == 0 && uc.Column.Index < 0 {
+			return nil, fmt.Errorf("%s: invalid uncertainty column index", pluginFile)
+		}
+		if uc.Coverage == 0 {
+			uc.Coverage = 1
+		}
+	}
+
+	for _, field := range []string{"date", "latitude", "longitude", "value"} {
+		if _, ok := def.Columns[field]; !ok {
+			return nil, fmt.Errorf("%s: no column given for field %s", pluginFile, field)
+		}
+	}
+
+	if _, ok := def.Columns["unit"]; !ok && len(def.Unit) == 0 {
+		return nil, fmt.Errorf("%s: either a unit or a unit column must be given", pluginFile)
+	}
+
+	if _, err = NewDateFormat(def.DateLayout, def.TimeZone); err != nil {
+		return nil, fmt.Errorf("%s: %v", pluginFile, err)
+	}
+
+	return def, nil
+}
+
+// Get all uncertainty columns of the parser definition
+func (def *ParserDefinition) uncertaintyColumns() []*UncertaintyColumn {
+
+	var cols []*UncertaintyColumn
+
+	if def.Uncertainty != nil {
+		cols = append(cols, def.Uncertainty)
+	}
+
+	for _, mc := range def.Measurements {
+		if mc.Uncertainty != nil {
+			cols = append(cols, mc.Uncertainty)
+		}
+	}
+
+	return cols
+}
+
+// NewSampleReaderDelimited Create a new sample reader reading delimited sample lines from r
+func NewSampleReaderDelimited(pluginFile string, r io.Reader) (SampleReader, error) {
+
+	def, err := LoadParserDefinition(pluginFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize a sample reader structure
+	sr := new(SampleReaderDelimited)
+	sr.pluginFile = pluginFile
+	sr.def = *def
+	sr.dateFormat, _ = NewDateFormat(def.DateLayout, def.TimeZone)
+	sr.scanner = bufio.NewScanner(r)
+	sr.lineNum = 0
+
+	// Attributes are written in name order
+	for name := range sr.def.Attributes {
+		sr.attrNames = append(sr.attrNames, name)
+	}
+	sort.Strings(sr.attrNames)
+
+	for name := range sr.def.Measurements {
+		sr.chanNames = append(sr.chanNames, name)
+	}
+	sort.Strings(sr.chanNames)
+
+	// Columns referenced by name are resolved when the header line is read
+	if !sr.def.Header {
+		sr.columns = make(map[string]int)
+		for field, col := range sr.def.Columns {
+			sr.columns[field] = col.Index
+		}
+		for _, name := range sr.attrNames {
+			sr.attrCols = append(sr.attrCols, sr.def.Attributes[name].Index)
+		}
+		for _, name := range sr.chanNames {
+			sr.chanCols = append(sr.chanCols, sr.def.Measurements[name].Column.Index)
+		}
+		sr.uncCols = make(map[*UncertaintyColumn]int)
+		for _, uc := range sr.def.uncertaintyColumns() {
+			sr.uncCols[uc] = uc.Column.Index
+		}
+	}
+
+	return sr, nil
+}
+
+// Read the next line from the sample file and make a sample structure from it
+func (sr *SampleReaderDelimited) Read() (*Sample, bool, error) {
+
+	for sr.scanner.Scan() {
+
+		sr.lineNum++
+
+		line := sr.scanner.Text()
+		if sr.lineNum == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if sr.lineNum <= sr.def.SkipLines {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+
+		if len(sr.def.Comment) > 0 && strings.HasPrefix(trimmed, sr.def.Comment) {
+			continue
+		}
+
+		fields, err := sr.split(line)
+		if err != nil {
+			return nil, false, &LineError{PluginFile: sr.pluginFile, Line: sr.lineNum, Text: line, Err: err}
+		}
+
+		if sr.columns == nil {
+			err := sr.readHeader(fields)
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+
+		sample, err := sr.getSample(fields)
+		if err != nil {
+			return nil, false, &LineError{PluginFile: sr.pluginFile, Line: sr.lineNum, Text: line, Err: err}
+		}
+
+		return sample, true, nil
+	}
+
+	err := sr.scanner.Err()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return nil, false, nil
+}
+
+// LineNumber Get the number of the last line read from the sample file
+func (sr *SampleReaderDelimited) LineNumber() int {
+
+	return sr.lineNum
+}
+
+// Close the sample reader and clean up. The underlying reader is left open
+func (sr *SampleReaderDelimited) Close() error {
+
+	return nil
+}
+
+// Split a line into trimmed fields
+func (sr *SampleReaderDelimited) split(line string) ([]string, error) {
+
+	if len(sr.def.Delimiter) == 0 {
+		return strings.Fields(line), nil
+	}
+
+	// Quoted fields can hold the delimiter
+	cr := csv.NewReader(strings.NewReader(line))
+	cr.Comma, _ = utf8.DecodeRuneInString(sr.def.Delimiter)
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	fields, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+	}
+
+	return fields, nil
+}
+
+// Resolve the column indices from the header line
+func (sr *SampleReaderDelimited) readHeader(names []string) error {
+
+	sr.columns = make(map[string]int)
+
+	for field, col := range sr.def.Columns {
+		idx, err := sr.resolveColumn(names, col)
+		if err != nil {
+			return err
+		}
+		sr.columns[field] = idx
+	}
+
+	for _, name := range sr.attrNames {
+		idx, err := sr.resolveColumn(names, sr.def.Attributes[name])
+		if err != nil {
+			return err
+		}
+		sr.attrCols = append(sr.attrCols, idx)
+	}
+
+	for _, name := range sr.chanNames {
+		idx, err := sr.resolveColumn(names, sr.def.Measurements[name].Column)
+		if err != nil {
+			return err
+		}
+		sr.chanCols = append(sr.chanCols, idx)
+	}
+
+	sr.uncCols = make(map[*UncertaintyColumn]int)
+	for _, uc := range sr.def.uncertaintyColumns() {
+		idx, err := sr.resolveColumn(names, uc.Column)
+		if err != nil {
+			return err
+		}
+		sr.uncCols[uc] = idx
+	}
+
+	return nil
+}
+
+// Get the index of a column, looking up named columns in the header line
+func (sr *SampleReaderDelimited) resolveColumn(names []string, col Column) (int, error) {
+
+	if len(col.Name) == 0 {
+		return col.Index, nil
+	}
+
+	for i, name := range names {
+		if strings.EqualFold(name, col.Name) {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("%s line %d: column %s not found in header", sr.pluginFile, sr.lineNum, col.Name)
+}
+
+// Get the value of a mapped field from a split line
+func (sr *SampleReaderDelimited) field(fields []string, name string) (string, bool, error) {
+
+	idx, ok := sr.columns[name]
+	if !ok {
+		return "", false, nil
+	}
+
+	if idx >= len(fields) {
+		return "", false, errors.New(name + " column " + strconv.Itoa(idx) + " is missing")
+	}
+
+	return fields[idx], true, nil
+}
+
+// Get a mapped field as a decimal number
+func (sr *SampleReaderDelimited) floatField(fields []string, name string) (float64, error) {
+
+	f, ok, err := sr.field(fields, name)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	v, err := strconv.ParseFloat(f, 64)
+	if err != nil {
+		return 0, errors.New("invalid " + name + " " + strconv.Quote(f))
+	}
+
+	return v, nil
+}
+
+// Get an uncertainty from a split line
+func (sr *SampleReaderDelimited) uncertainty(fields []string, uc *UncertaintyColumn) (*Uncertainty, error) {
+
+	if uc == nil {
+		return nil, nil
+	}
+
+	idx := sr.uncCols[uc]
+	if idx >= len(fields) {
+		return nil, errors.New("uncertainty column " + strconv.Itoa(idx) + " is missing")
+	}
+
+	v, err := strconv.ParseFloat(fields[idx], 64)
+	if err != nil {
+		return nil, errors.New("invalid uncertainty " + strconv.Quote(fields[idx]))
+	}
+
+	return &Uncertainty{Value: v, Relative: uc.Relative, Coverage: uc.Coverage}, nil
+}
+
+// Populate a sample structure from a split line
+func (sr *SampleReaderDelimited) getSample(fields []string) (*Sample, error) {
+
+	s := new(Sample)
+
+	ds, _, err := sr.field(fields, "date")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Date, err = sr.dateFormat.Parse(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Latitude, err = sr.floatField(fields, "latitude")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Longitude, err = sr.floatField(fields, "longitude")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Altitude, err = sr.floatField(fields, "altitude")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Value, err = sr.floatField(fields, "value")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Uncertainty, err = sr.uncertainty(fields, sr.def.Uncertainty)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Unit = sr.def.Unit
+
+	unit, ok, err := sr.field(fields, "unit")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		s.Unit = unit
+	}
+
+	for i, name := range sr.chanNames {
+		idx := sr.chanCols[i]
+		if idx >= len(fields) {
+			return nil, errors.New("measurement " + name + " column " + strconv.Itoa(idx) + " is missing")
+		}
+
+		v, err := strconv.ParseFloat(fields[idx], 64)
+		if err != nil {
+			return nil, errors.New("invalid measurement " + name + " " + strconv.Quote(fields[idx]))
+		}
+
+		mc := sr.def.Measurements[name]
+		u, err := sr.uncertainty(fields, mc.Uncertainty)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Measurements = append(s.Measurements, Measurement{Name: name, Value: v, Unit: mc.Unit, Uncertainty: u})
+	}
+
+	for i, name := range sr.attrNames {
+		idx := sr.attrCols[i]
+		if idx >= len(fields) {
+			return nil, errors.New("attribute " + name + " column " + strconv.Itoa(idx) + " is missing")
+		}
+		s.Attributes = append(s.Attributes, Attribute{Name: name, Value: fields[idx]})
+	}
+
+	return s, nil
+}
+
+// Check if a name is a sample field that can be mapped to a column
+func isDefinitionField(name string) bool {
+
+	for _, f := range definitionFields {
+		if f == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,164 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Read all samples from a sample file with a parser definition
+func readDelimited(t *testing.T, definition, input string) ([]*Sample, error) {
+
+	pluginFile := filepath.Join(t.TempDir(), "plugin.json")
+	err := ioutil.WriteFile(pluginFile, []byte(definition), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := NewSampleReaderDelimited(pluginFile, strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	defer sr.Close()
+
+	var samples []*Sample
+	for {
+		s, ok, err := sr.Read()
+		if err != nil {
+			return samples, err
+		}
+		if !ok {
+			return samples, nil
+		}
+		samples = append(samples, s)
+	}
+}
+
+func TestSampleReaderDelimited(t *testing.T) {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		definition string
+		input      string
+		samples    []Sample
+	}{
+		{"quoted delimiters",
+			`{"delimiter": ",", "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3},
+			"attributes": {"note": 4}}`,
+			"2015-03-01T10:00:00, \"59.9\", 10.7, 0.1, \"north, by the road\"\n",
+			[]Sample{{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h",
+				Attributes: Attributes{{Name: "note", Value: "north, by the road"}}}}},
+		{"quotes inside fields",
+			`{"delimiter": ";", "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3},
+			"attributes": {"note": 4}}`,
+			"2015-03-01T10:00:00;59.9;10.7;0.1;a \"b\" c\n",
+			[]Sample{{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h",
+				Attributes: Attributes{{Name: "note", Value: "a \"b\" c"}}}}},
+		{"header names",
+			`{"delimiter": ",", "header": true, "skipLines": 1, "comment": "#",
+			"columns": {"date": "Time", "latitude": "LAT", "longitude": "lon", "value": "dose", "unit": "Unit"},
+			"attributes": {"detector": "id"}}`,
+			"exported by the logger\nid,dose,unit,lon,lat,time\n# comment\nD1,0.1,uSv/h,10.7,59.9,2015-03-01T10:00:00\n\nD2,0.2,nSv/h,10.8,59.8,2015-03-01T10:00:00\n",
+			[]Sample{{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h",
+				Attributes: Attributes{{Name: "detector", Value: "D1"}}},
+				{Date: date, Latitude: 59.8, Longitude: 10.8, Value: 0.2, Unit: "nSv/h",
+					Attributes: Attributes{{Name: "detector", Value: "D2"}}}}},
+		{"whitespace",
+			`{"unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3}}`,
+			"2015-03-01T10:00:00   59.9\t10.7 0.1\n",
+			[]Sample{{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h"}}},
+		{"time zone",
+			`{"delimiter": ",", "timeZone": "Europe/Oslo", "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3}}`,
+			"2015-03-01T11:00:00,59.9,10.7,0.1\n2015-07-01T12:00:00,59.9,10.7,0.2\n2015-03-01T10:00:00Z,59.9,10.7,0.3\n",
+			[]Sample{{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h"},
+				{Date: date.AddDate(0, 4, 0), Latitude: 59.9, Longitude: 10.7, Value: 0.2, Unit: "uSv/h"},
+				{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.3, Unit: "uSv/h"}}},
+		{"time zone and layout",
+			`{"delimiter": ",", "dateLayout": "02.01.2006 15:04", "timeZone": "America/New_York", "unit": "uSv/h",
+			"columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3}}`,
+			"01.03.2015 05:00,59.9,10.7,0.1\n",
+			[]Sample{{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h"}}},
+	}
+
+	for _, test := range tests {
+
+		samples, err := readDelimited(t, test.definition, test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(samples) != len(test.samples) {
+			t.Errorf("%s: %d samples read, expected %d", test.name, len(samples), len(test.samples))
+			continue
+		}
+
+		for i, s := range samples {
+
+			e := test.samples[i]
+			if !s.Date.Equal(e.Date) {
+				t.Errorf("%s: sample %d: date is %v, expected %v", test.name, i+1, s.Date, e.Date)
+			}
+
+			// Dates are compared above, as their time zones differ
+			s.Date = e.Date
+			if !reflect.DeepEqual(*s, e) {
+				t.Errorf("%s: sample %d is %+v, expected %+v", test.name, i+1, *s, e)
+			}
+		}
+	}
+}
+
+func TestSampleReaderDelimitedErrors(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		definition string
+		input      string
+		err        string
+	}{
+		{"name without header",
+			`{"unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": "dose"}}`,
+			"", "column dose is referenced by name, but header is not set"},
+		{"column not in header",
+			`{"delimiter": ",", "header": true, "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": "dose"}}`,
+			"time,lat,lon,value\n", "line 1: column dose not found in header"},
+		{"unknown time zone",
+			`{"timeZone": "Nowhere/Town", "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3}}`,
+			"", "unknown time zone Nowhere/Town"},
+		{"missing column",
+			`{"delimiter": ",", "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3}}`,
+			"2015-03-01T10:00:00,59.9,10.7,0.1\n2015-03-01T10:00:00,59.9,10.7\n", "line 2: value column 3 is missing"},
+		{"invalid value",
+			`{"delimiter": ",", "unit": "uSv/h", "columns": {"date": 0, "latitude": 1, "longitude": 2, "value": 3}}`,
+			"2015-03-01T10:00:00,59.9,10.7,\"0.1 uSv/h\"\n", "line 1: invalid value \"0.1 uSv/h\""},
+	}
+
+	for _, test := range tests {
+
+		_, err := readDelimited(t, test.definition, test.input)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error is %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
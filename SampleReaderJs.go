@@ -0,0 +1,752 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// EndLine Line number of the samples made by the end hook of a plugin
+const EndLine = -1
+
+// SampleReaderJS Structure representing a sample reader using a javascript plugin
+type SampleReaderJS struct {
+	pluginFile string
+	opts       ReaderOptions
+	scanner    *bufio.Scanner
+	lineNum    int
+	vm         *otto.Otto
+	dateFormat *DateFormat
+	elapsed    time.Duration // Time spent in the plugin
+	fileAttrs  otto.Value    // Attributes object of the begin hook, its values are added to every sample
+	begun      bool
+	ended      bool
+	emitted    []*Sample // Samples emitted by the running plugin function
+	queue      []*Sample // Samples of the last line, not yet read
+	queueLine  int       // Line the queued samples were made from, 0 for the begin hook and EndLine for the end hook
+}
+
+// NewSampleReaderJS Create a new javascript sample reader reading sample lines from r.
+// The SampleFile option is passed to the begin hook of the plugin
+func NewSampleReaderJS(pluginFile string, r io.Reader, opts ReaderOptions) (SampleReader, error) {
+
+	// Initialize a sample reader structure
+	sr := new(SampleReaderJS)
+	sr.pluginFile = pluginFile
+	sr.opts = opts
+	sr.scanner = bufio.NewScanner(r)
+	sr.lineNum = 0
+
+	// Create a otto javascript runtime
+	var err error
+	sr.vm, err = sr.createPluginRuntime()
+	if err != nil {
+		return nil, err
+	}
+
+	// Plugins can declare how their dates are interpreted
+	layout, err := sr.getOptionalString("dateLayout")
+	if err != nil {
+		return nil, err
+	}
+
+	timeZone, err := sr.getOptionalString("timeZone")
+	if err != nil {
+		return nil, err
+	}
+
+	sr.dateFormat, err = NewDateFormat(layout, timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+// Read the next sample from the sample file using a javascript plugin.
+// A line can make any number of samples, which are queued and read one at a time.
+// The begin hook of the plugin is called before the first line, and the end hook after the last line
+func (sr *SampleReaderJS) Read() (*Sample, bool, error) {
+
+	if !sr.begun {
+
+		sr.begun = true
+
+		err := sr.limit(func() error {
+			sr.emitted = nil
+			_, err := sr.execHook("begin", sr.opts.SampleFile, sr.fileAttrs)
+			return err
+		})
+		if err != nil {
+			return nil, false, sr.hookError("begin", err)
+		}
+
+		sr.queue = sr.emitted
+		sr.queueLine = 0
+	}
+
+	for len(sr.queue) == 0 && sr.scanner.Scan() {
+
+		sr.lineNum++
+
+		line := sr.scanner.Text()
+
+		var samples []*Sample
+		err := sr.limit(func() error {
+			var err error
+			samples, err = sr.execPlugin(line, sr.lineNum)
+			return err
+		})
+
+		// The sample file can't be read any further when the plugin has used up its time
+		if err == errPluginFileTimeout {
+			return nil, false, sr.hookError("parseLine", err)
+		}
+
+		if err != nil {
+			return nil, false, &LineError{PluginFile: sr.pluginFile, Line: sr.lineNum, Text: line, Err: err}
+		}
+
+		sr.queue = samples
+		sr.queueLine = sr.lineNum
+	}
+
+	// The end hook can make the last samples, like parseLine
+	if len(sr.queue) == 0 && !sr.ended {
+
+		err := sr.scanner.Err()
+		if err != nil {
+			return nil, false, err
+		}
+
+		sr.ended = true
+
+		err = sr.limit(func() error {
+			var err error
+			sr.queue, err = sr.execEnd()
+			return err
+		})
+		if err != nil {
+			return nil, false, sr.hookError("end", err)
+		}
+		sr.queueLine = EndLine
+	}
+
+	if len(sr.queue) == 0 {
+		return nil, false, nil
+	}
+
+	sample := sr.queue[0]
+	sr.queue = sr.queue[1:]
+
+	return sample, true, nil
+}
+
+// LineNumber Get the number of the line the last sample read was made from.
+// Samples made by the begin hook have line 0, and samples made by the end hook have EndLine
+func (sr *SampleReaderJS) LineNumber() int {
+
+	return sr.queueLine
+}
+
+// Close the sample reader and clean up. The underlying reader is left open
+func (sr *SampleReaderJS) Close() error {
+
+	sr.vm = nil
+	return nil
+}
+
+// Create a javascript runtime
+func (sr *SampleReaderJS) createPluginRuntime() (*otto.Otto, error) {
+
+	// Read plugin file
+	b, err := ioutil.ReadFile(sr.pluginFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create runtime and load plugin file
+	sr.vm, err = newPluginRuntime(sr.pluginFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The context object keeps the state of the plugin between the hooks and lines of a sample file
+	context, err := sr.vm.Object("({})")
+	if err != nil {
+		return nil, err
+	}
+
+	err = sr.vm.Set("context", context)
+	if err != nil {
+		return nil, err
+	}
+
+	fileAttrs, err := sr.vm.Object("({})")
+	if err != nil {
+		return nil, err
+	}
+	sr.fileAttrs = fileAttrs.Value()
+
+	// Plugins can emit any number of sample objects while parsing a line
+	err = sr.vm.Set("emit", sr.emit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sr.limit(func() error {
+		_, err := sr.vm.Run(string(b))
+		return err
+	})
+	if err != nil {
+		return nil, errors.New(sr.pluginFile + ": " + err.Error())
+	}
+
+	return sr.vm, nil
+}
+
+// Call an optional plugin function. Returns undefined if the plugin does not define it
+func (sr *SampleReaderJS) execHook(name string, args ...interface{}) (otto.Value, error) {
+
+	fn, err := sr.vm.Get(name)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	if !fn.IsFunction() {
+		return otto.UndefinedValue(), nil
+	}
+
+	return fn.Call(otto.UndefinedValue(), args...)
+}
+
+// Execute the end hook of the plugin and extract the last samples
+func (sr *SampleReaderJS) execEnd() ([]*Sample, error) {
+
+	sr.emitted = nil
+
+	retVal, err := sr.execHook("end")
+	if err != nil {
+		return nil, err
+	}
+
+	return sr.getSamples(retVal)
+}
+
+// The emit function of the plugin runtime, converting a sample object when it is emitted.
+// Invalid samples are thrown as type errors
+func (sr *SampleReaderJS) emit(call otto.FunctionCall) otto.Value {
+
+	v := call.Argument(0)
+	if !v.IsObject() {
+		panic(call.Otto.MakeTypeError("emit needs a sample object"))
+	}
+
+	s, err := sr.getSample(v.Object())
+	if err != nil {
+		panic(call.Otto.MakeTypeError(err.Error()))
+	}
+
+	sr.emitted = append(sr.emitted, s)
+
+	return otto.UndefinedValue()
+}
+
+// Make an error for a plugin hook failing, with the plugin and the last line read, if any.
+// Unlike line errors, these stop the reading of the sample file
+func (sr *SampleReaderJS) hookError(hook string, err error) error {
+
+	if err == errPluginFileTimeout {
+		err = fmt.Errorf("plugin did not finish the sample file within %v", sr.opts.FileTimeout)
+	}
+
+	if sr.lineNum == 0 {
+		return fmt.Errorf("%s: %s: %v", sr.pluginFile, hook, err)
+	}
+
+	return fmt.Errorf("%s line %d: %s: %v", sr.pluginFile, sr.lineNum, hook, err)
+}
+
+// Execute plugin and extract the samples of a line
+func (sr *SampleReaderJS) execPlugin(line string, lineNum int) ([]*Sample, error) {
+
+	sr.emitted = nil
+
+	// Prepare arguments
+	argLineNum, err := sr.vm.ToValue(lineNum)
+	if err != nil {
+		return nil, err
+	}
+
+	argLine, err := sr.vm.ToValue(line)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute plugin
+	retVal, err := sr.vm.Call("parseLine", nil, argLineNum, argLine)
+	if err != nil {
+		return nil, err
+	}
+
+	return sr.getSamples(retVal)
+}
+
+// Helper function to collect the samples made by a plugin function: the samples emitted,
+// then the sample objects of a returned array, or a sample from the global variables if it returned true
+func (sr *SampleReaderJS) getSamples(retVal otto.Value) ([]*Sample, error) {
+
+	samples := sr.emitted
+	sr.emitted = nil
+
+	if retVal.Class() == "Array" {
+
+		obj := retVal.Object()
+
+		lv, err := obj.Get("length")
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := lv.ToInteger()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := int64(0); i < length; i++ {
+			v, err := obj.Get(strconv.FormatInt(i, 10))
+			if err != nil {
+				return nil, err
+			}
+
+			if !v.IsObject() {
+				return nil, fmt.Errorf("sample %d of the returned array is not an object", i)
+			}
+
+			s, err := sr.getSample(v.Object())
+			if err != nil {
+				return nil, fmt.Errorf("sample %d of the returned array: %v", i, err)
+			}
+
+			samples = append(samples, s)
+		}
+
+		return samples, nil
+	}
+
+	// Extract and evaluate return value
+	ret, err := retVal.ToBoolean()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ret {
+		return samples, nil
+	}
+
+	// Extract a full sample from javascript runtime
+	sample, err := sr.getSample(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(samples, sample), nil
+}
+
+// Helper function to populate a sample structure with a single sample,
+// from the fields of a sample object or from the global variables if obj is nil
+func (sr *SampleReaderJS) getSample(obj *otto.Object) (*Sample, error) {
+
+	var err error
+	var v otto.Value
+
+	get := sr.vm.Get
+	if obj != nil {
+		get = obj.Get
+	}
+
+	s := new(Sample)
+
+	// Extract date field from javascript runtime
+	v, err = get("date")
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.IsDefined() {
+		return nil, errors.New("date not defined")
+	}
+
+	s.Date, err = sr.getDate(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract latitude field from javascript runtime
+	v, err = get("latitude")
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.IsDefined() {
+		return nil, errors.New("latitude not defined")
+	}
+
+	s.Latitude, err = v.ToFloat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract longitude field from javascript runtime
+	v, err = get("longitude")
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.IsDefined() {
+		return nil, errors.New("longitude not defined")
+	}
+
+	s.Longitude, err = v.ToFloat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract altitude field from javascript runtime
+	v, err = get("altitude")
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.IsDefined() {
+		return nil, errors.New("altitude not defined")
+	}
+
+	s.Altitude, err = v.ToFloat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract optional measurements object from javascript runtime
+	v, err = get("measurements")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Measurements, err = sr.getMeasurements(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract value field from javascript runtime.
+	// Without a value, the first measurement is the primary value and unit
+	v, err = get("value")
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.IsDefined() && len(s.Measurements) > 0 {
+
+		s.Value = s.Measurements[0].Value
+		s.Unit = s.Measurements[0].Unit
+		s.Uncertainty = s.Measurements[0].Uncertainty
+
+	} else {
+
+		if !v.IsDefined() {
+			return nil, errors.New("value not defined")
+		}
+
+		s.Value, err = v.ToFloat()
+		if err != nil {
+			return nil, err
+		}
+
+		// Extract unit field from javascript runtime
+		v, err = get("unit")
+		if err != nil {
+			return nil, err
+		}
+
+		if !v.IsDefined() {
+			return nil, errors.New("unit not defined")
+		}
+
+		s.Unit, err = v.ToString()
+		if err != nil {
+			return nil, err
+		}
+
+		// Extract optional uncertainty field from javascript runtime
+		v, err = get("uncertainty")
+		if err != nil {
+			return nil, err
+		}
+
+		s.Uncertainty, err = sr.getUncertainty(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Extract optional attributes object from javascript runtime
+	v, err = get("attributes")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Attributes, err = sr.getAttributes(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the attributes of the sample file, unless the sample has attributes of the same names
+	fileAttrs, err := sr.getAttributes(sr.fileAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range fileAttrs {
+		if _, ok := s.Attributes.Get(attr.Name); !ok {
+			s.Attributes = append(s.Attributes, attr)
+		}
+	}
+
+	return s, nil
+}
+
+// Helper function to convert a javascript object, with a {value, unit} object for each channel, to sample measurements
+func (sr *SampleReaderJS) getMeasurements(v otto.Value) ([]Measurement, error) {
+
+	if !v.IsDefined() || v.IsNull() {
+		return nil, nil
+	}
+
+	if !v.IsObject() {
+		return nil, errors.New("measurements is not an object")
+	}
+
+	obj := v.Object()
+	var measurements []Measurement
+
+	for _, key := range obj.Keys() {
+		mv, err := obj.Get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !mv.IsObject() {
+			return nil, errors.New("measurement " + key + " is not an object")
+		}
+
+		m := Measurement{Name: key}
+
+		fv, err := mv.Object().Get("value")
+		if err != nil {
+			return nil, err
+		}
+
+		if !fv.IsDefined() {
+			return nil, errors.New("value of measurement " + key + " not defined")
+		}
+
+		m.Value, err = fv.ToFloat()
+		if err != nil {
+			return nil, err
+		}
+
+		uv, err := mv.Object().Get("unit")
+		if err != nil {
+			return nil, err
+		}
+
+		if uv.IsDefined() {
+			m.Unit, err = uv.ToString()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cv, err := mv.Object().Get("uncertainty")
+		if err != nil {
+			return nil, err
+		}
+
+		m.Uncertainty, err = sr.getUncertainty(cv)
+		if err != nil {
+			return nil, err
+		}
+
+		measurements = append(measurements, m)
+	}
+
+	return measurements, nil
+}
+
+// Helper function to convert a javascript uncertainty, given as an absolute number
+// or a {value, relative, coverage} object
+func (sr *SampleReaderJS) getUncertainty(v otto.Value) (*Uncertainty, error) {
+
+	if !v.IsDefined() || v.IsNull() {
+		return nil, nil
+	}
+
+	u := &Uncertainty{Coverage: 1}
+
+	if !v.IsObject() {
+		f, err := v.ToFloat()
+		if err != nil {
+			return nil, err
+		}
+		u.Value = f
+		return u, nil
+	}
+
+	obj := v.Object()
+
+	fv, err := obj.Get("value")
+	if err != nil {
+		return nil, err
+	}
+
+	if !fv.IsDefined() {
+		return nil, errors.New("uncertainty value not defined")
+	}
+
+	u.Value, err = fv.ToFloat()
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := obj.Get("relative")
+	if err != nil {
+		return nil, err
+	}
+
+	if rv.IsDefined() {
+		u.Relative, err = rv.ToBoolean()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kv, err := obj.Get("coverage")
+	if err != nil {
+		return nil, err
+	}
+
+	if kv.IsDefined() {
+		u.Coverage, err = kv.ToFloat()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// Helper function to convert a javascript object to sample attributes
+func (sr *SampleReaderJS) getAttributes(v otto.Value) (Attributes, error) {
+
+	if !v.IsDefined() || v.IsNull() {
+		return nil, nil
+	}
+
+	if !v.IsObject() {
+		return nil, errors.New("attributes is not an object")
+	}
+
+	obj := v.Object()
+	var attrs Attributes
+
+	for _, key := range obj.Keys() {
+		av, err := obj.Get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		attr := Attribute{Name: key}
+
+		switch {
+		case av.IsNumber():
+			attr.Value, err = av.ToFloat()
+		case av.IsBoolean():
+			attr.Value, err = av.ToBoolean()
+		case av.IsDefined() && !av.IsNull():
+			attr.Value, err = av.ToString()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		attrs = append(attrs, attr)
+	}
+
+	return attrs, nil
+}
+
+// Helper function to get an optional string variable from the javascript runtime
+func (sr *SampleReaderJS) getOptionalString(name string) (string, error) {
+
+	v, err := sr.vm.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	if !v.IsDefined() {
+		return "", nil
+	}
+
+	return v.ToString()
+}
+
+// Helper function to convert a javascript date, given as a string, a number since the Unix epoch or a Date object
+func (sr *SampleReaderJS) getDate(v otto.Value) (time.Time, error) {
+
+	if v.IsNumber() {
+		f, err := v.ToFloat()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return sr.dateFormat.FromEpoch(f), nil
+	}
+
+	if v.Class() == "Date" {
+		ms, err := v.Object().Call("getTime")
+		if err != nil {
+			return time.Time{}, err
+		}
+		f, err := ms.ToFloat()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f)*int64(time.Millisecond)).In(sr.dateFormat.Location), nil
+	}
+
+	ds, err := v.ToString()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return sr.dateFormat.Parse(ds)
+}
@@ -0,0 +1,198 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Read all samples from a sample file with a javascript plugin, with the line number of each sample
+func readJS(t *testing.T, source, input string, opts ReaderOptions) ([]*Sample, []int, error) {
+
+	sr, err := NewSampleReaderJS(writeTestPlugin(t, source), strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sr.Close()
+
+	var samples []*Sample
+	var lines []int
+	for {
+		s, ok, err := sr.Read()
+		if err != nil || !ok {
+			return samples, lines, err
+		}
+		samples = append(samples, s)
+		lines = append(lines, sr.(lineNumberReader).LineNumber())
+	}
+}
+
+func TestPluginHooks(t *testing.T) {
+
+	source := `
+function begin(fileName, fileAttributes) {
+	context.fileName = fileName;
+	context.fileAttributes = fileAttributes;
+	context.lines = 0;
+	fileAttributes.detector = "D1";
+}
+function parseLine(n, line) {
+	context.lines++;
+	if (n == 1) {
+		context.fileAttributes.serial = line;
+		return false;
+	}
+	date = "2015-03-01T10:00:00"; latitude = 59.9; longitude = 10.7; altitude = 0; unit = "uSv/h";
+	value = parseFloat(line);
+	attributes = line == "0.2" ? { detector: "D2" } : undefined;
+	return true;
+}
+function end() {
+	value = context.lines;
+	attributes = { file: context.fileName };
+	return true;
+}
+`
+
+	samples, lines, err := readJS(t, source, "SN42\n0.1\n0.2\n", ReaderOptions{SampleFile: "survey.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line       int
+		value      float64
+		attributes Attributes
+	}{
+		{2, 0.1, Attributes{{Name: "detector", Value: "D1"}, {Name: "serial", Value: "SN42"}}},
+		{3, 0.2, Attributes{{Name: "detector", Value: "D2"}, {Name: "serial", Value: "SN42"}}},
+		{EndLine, 3, Attributes{{Name: "file", Value: "survey.log"}, {Name: "detector", Value: "D1"}, {Name: "serial", Value: "SN42"}}},
+	}
+
+	if len(samples) != len(tests) {
+		t.Fatalf("%d samples read, expected %d", len(samples), len(tests))
+	}
+
+	for i, test := range tests {
+
+		s := samples[i]
+		if lines[i] != test.line || s.Value != test.value {
+			t.Errorf("sample %d: value %v from line %d, expected %v from line %d", i+1, s.Value, lines[i], test.value, test.line)
+		}
+
+		if !reflect.DeepEqual(s.Attributes, test.attributes) {
+			t.Errorf("sample %d: attributes are %v, expected %v", i+1, s.Attributes, test.attributes)
+		}
+	}
+}
+
+func TestPluginHookErrors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{"begin throws", `function begin() { throw new Error("no header"); } function parseLine() { return false; }`,
+			"begin: Error: no header"},
+		{"end throws", `function parseLine() { return false; } function end() { throw new Error("no footer"); }`,
+			"line 2: end: Error: no footer"},
+		{"end returns an invalid sample", `function parseLine() { return false; } function end() { return [{ value: 1 }]; }`,
+			"end: sample 0 of the returned array: date not defined"},
+	}
+
+	for _, test := range tests {
+
+		_, _, err := readJS(t, test.source, "a\nb\n", ReaderOptions{})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error is %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestPluginSampleOrder(t *testing.T) {
+
+	source := `
+function sample(v) { return { date: "2015-03-01T10:00:00", latitude: 59.9, longitude: 10.7, altitude: 0, value: v, unit: "uSv/h" }; }
+function begin() { emit(sample(1)); emit(sample(2)); }
+function parseLine(n, line) {
+	var v = parseFloat(line);
+	switch (n) {
+	case 1:
+		emit(sample(v));
+		return [sample(v + 0.1), sample(v + 0.2)];
+	case 2:
+		return false;
+	case 3:
+		emit(sample(v));
+		emit(sample(v + 0.1));
+		date = "2015-03-01T10:00:00"; latitude = 59.9; longitude = 10.7; altitude = 0; unit = "uSv/h";
+		value = v + 0.2;
+		return true;
+	case 4:
+		emit(sample(v));
+		return false;
+	}
+	return [];
+}
+function end() { emit(sample(9)); return [sample(9.1)]; }
+`
+
+	samples, lines, err := readJS(t, source, "10\n20\n30\n40\n50\n", ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []float64
+	for _, s := range samples {
+		values = append(values, s.Value)
+	}
+
+	expectedValues := []float64{1, 2, 10, 10.1, 10.2, 30, 30.1, 30.2, 40, 9, 9.1}
+	expectedLines := []int{0, 0, 1, 1, 1, 3, 3, 3, 4, EndLine, EndLine}
+
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("values are %v, expected %v", values, expectedValues)
+	}
+
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("lines are %v, expected %v", lines, expectedLines)
+	}
+}
+
+func TestPluginEmitErrors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{"emit without a sample", `function parseLine() { emit(1); return false; }`, "line 1: TypeError: emit needs a sample object"},
+		{"emit an invalid sample", `function parseLine() { emit({ date: "2015-03-01T10:00:00" }); return false; }`,
+			"line 1: TypeError: latitude not defined"},
+		{"array with a number", `function parseLine() { return [1]; }`, "line 1: sample 0 of the returned array is not an object"},
+	}
+
+	for _, test := range tests {
+
+		_, _, err := readJS(t, test.source, "a\n", ReaderOptions{})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error is %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+// SourceAttribute Name of the attribute holding the name of the sample file a sample was read from
+const SourceAttribute = "source"
+
+// SampleReaderSource Structure representing a sample reader adding the name of the sample file
+// to each sample read by another sample reader. Used when several sample files are merged into one output
+type SampleReaderSource struct {
+	Source string
+	sr     SampleReader
+}
+
+// NewSampleReaderSource Create a new sample reader adding the source attribute to the samples read by sr
+func NewSampleReaderSource(sr SampleReader, source string) SampleReader {
+
+	return &SampleReaderSource{Source: source, sr: sr}
+}
+
+// Read Read the next sample and add the source attribute to it
+func (sr *SampleReaderSource) Read() (*Sample, bool, error) {
+
+	s, more, err := sr.sr.Read()
+	if s != nil {
+		s.Attributes = append(s.Attributes, Attribute{Name: SourceAttribute, Value: sr.Source})
+	}
+
+	return s, more, err
+}
+
+// Close Close the underlying sample reader
+func (sr *SampleReaderSource) Close() error {
+
+	return sr.sr.Close()
+}
+
+// Get the name of the sample file a sample was read from, or the given default without a source attribute
+func sampleSource(s *Sample, def string) string {
+
+	v, ok := s.Attributes.Get(SourceAttribute)
+	if !ok {
+		return def
+	}
+
+	return FormatAttributeValue(v)
+}
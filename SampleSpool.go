@@ -0,0 +1,155 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// SampleSpool Structure representing a temporary on-disk buffer of samples.
+// Writers that need global statistics before they can produce any output
+// spool their samples here instead of keeping them in memory
+type SampleSpool struct {
+	Channel  string
+	Unit     string
+	Count    int
+	MinValue float64
+	MaxValue float64
+	MinAbove float64 // Smallest value above zero, zero if there is none
+	fd       *os.File
+	fw       *bufio.Writer
+	enc      *gob.Encoder
+}
+
+// NewSampleSpool Create a new sample spool backed by a temporary file.
+// The min and max values are taken from the given measurement channel
+func NewSampleSpool(channel string) (*SampleSpool, error) {
+
+	sp := new(SampleSpool)
+	sp.Channel = channel
+
+	var err error
+	sp.fd, err = ioutil.TempFile("", "sampleconverter-")
+	if err != nil {
+		return nil, err
+	}
+
+	sp.fw = bufio.NewWriter(sp.fd)
+	sp.enc = gob.NewEncoder(sp.fw)
+
+	return sp, nil
+}
+
+// Write Append a sample to the spool and update the min and max values. The unit is taken from the first sample
+func (sp *SampleSpool) Write(s *Sample) error {
+
+	m, ok := s.Channel(sp.Channel)
+	if !ok {
+		return errors.New("Sample has no measurement " + sp.Channel)
+	}
+
+	err := sp.enc.Encode(s)
+	if err != nil {
+		return err
+	}
+
+	if sp.Count == 0 {
+		sp.Unit = m.Unit
+		sp.MinValue = m.Value
+		sp.MaxValue = m.Value
+	} else {
+		if m.Value < sp.MinValue {
+			sp.MinValue = m.Value
+		}
+		if m.Value > sp.MaxValue {
+			sp.MaxValue = m.Value
+		}
+	}
+	if m.Value > 0 && (sp.MinAbove == 0 || m.Value < sp.MinAbove) {
+		sp.MinAbove = m.Value
+	}
+	sp.Count++
+
+	return nil
+}
+
+// Each Call fn for every spooled sample, in the order they were written
+func (sp *SampleSpool) Each(fn func(s *Sample) error) error {
+
+	err := sp.fw.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = sp.fd.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+
+	dec := gob.NewDecoder(bufio.NewReader(sp.fd))
+
+	for {
+		s := new(Sample)
+		err = dec.Decode(s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Leave the file position at the end so more samples can be appended
+	_, err = sp.fd.Seek(0, 2)
+	return err
+}
+
+// SortedValues Get the values of the spool channel for all spooled samples in increasing order
+func (sp *SampleSpool) SortedValues() ([]float64, error) {
+
+	values := make([]float64, 0, sp.Count)
+
+	err := sp.Each(func(s *Sample) error {
+		m, _ := s.Channel(sp.Channel)
+		values = append(values, m.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Float64s(values)
+
+	return values, nil
+}
+
+// Close Remove the spool file
+func (sp *SampleSpool) Close() error {
+
+	sp.fd.Close()
+	return os.Remove(sp.fd.Name())
+}
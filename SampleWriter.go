@@ -13,10 +13,27 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 // SampleWriter Common interface for sample readers
 type SampleWriter interface {
 	Write(s *Sample) error
 	Close() error
 }
+
+// SampleAborter Interface for sample writers holding resources that must be released
+// when a conversion fails, without finishing the output as Close does
+type SampleAborter interface {
+	Abort() error
+}
+
+// AbortSampleWriter Give up a failed conversion. The output is left unfinished,
+// and writers implementing SampleAborter release their resources
+func AbortSampleWriter(sw SampleWriter) error {
+
+	if a, ok := sw.(SampleAborter); ok {
+		return a.Abort()
+	}
+
+	return nil
+}
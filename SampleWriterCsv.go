@@ -13,45 +13,99 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
 	"encoding/csv"
-	"os"
+	"errors"
+	"fmt"
+	"io"
 	"strconv"
 )
 
-// SampleWriterCsv Structure representing a sample writer
+// SampleWriterCsv Structure representing a sample writer.
+// The uncertainty, measurement and attribute columns are taken from the first sample, so the rows can be
+// written as the samples are read. A later sample with a value the first sample has no column for is
+// rejected, instead of leaving the value out. Uncertainties are written as absolute values
 type SampleWriterCsv struct {
-	CsvFile       string
 	UseScientific bool
-	fd            *os.File
 	fw            *csv.Writer
+	uncertainty   bool
+	chanNames     []string
+	chanUncs      []bool
+	attrNames     []string
+	header        bool
+	count         int // Number of samples written
 }
 
-// NewSampleWriterCsv Create a new CSV sample writer
-func NewSampleWriterCsv(csvFile string, useScientific bool) (SampleWriter, error) {
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "csv",
+		Description: "Comma separated values",
+		Extension:   ".csv",
+		Options:     []string{OptionScientific},
+		New:         NewSampleWriterCsv,
+	})
+}
+
+// NewSampleWriterCsv Create a new CSV sample writer writing to w
+func NewSampleWriterCsv(w io.Writer, opts WriterOptions) (SampleWriter, error) {
 
 	// Initialize a sample writer
 	sw := new(SampleWriterCsv)
-	sw.CsvFile = csvFile
-	sw.UseScientific = useScientific
+	sw.UseScientific = opts.UseScientific
 
-	var err error
-	sw.fd, err = os.Create(sw.CsvFile)
-	if err != nil {
-		return nil, err
+	sw.fw = csv.NewWriter(w)
+
+	return sw, nil
+}
+
+// Write the header line, including columns for each measurement and attribute of the first sample
+func (sw *SampleWriterCsv) writeHeader(s *Sample) error {
+
+	sw.header = true
+
+	if s != nil {
+		sw.uncertainty = s.Uncertainty != nil
+		sw.chanNames = s.ChannelNames()
+		for _, m := range s.Measurements {
+			sw.chanUncs = append(sw.chanUncs, m.Uncertainty != nil)
+		}
+		sw.attrNames = s.Attributes.Names()
 	}
 
-	sw.fw = csv.NewWriter(sw.fd)
-	sw.fw.Write([]string{"Date", "Latitude", "Longitude", "Altitude", "Value", "Unit"})
+	columns := []string{"Date", "Latitude", "Longitude", "Altitude", "Value", "Unit"}
+	if sw.uncertainty {
+		columns = append(columns, "Uncertainty", "Coverage Factor")
+	}
 
-	return sw, nil
+	for i, name := range sw.chanNames {
+		columns = append(columns, name, name+" Unit")
+		if sw.chanUncs[i] {
+			columns = append(columns, name+" Uncertainty", name+" Coverage Factor")
+		}
+	}
+
+	return sw.fw.Write(append(columns, sw.attrNames...))
 }
 
 // Write Write a sample to the csv file
 func (sw *SampleWriterCsv) Write(s *Sample) error {
 
+	if !sw.header {
+		err := sw.writeHeader(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	sw.count++
+	err := sw.checkColumns(s)
+	if err != nil {
+		return fmt.Errorf("Sample %d: %v", sw.count, err)
+	}
+
 	// Set the number format
 	mod := byte('f')
 	if sw.UseScientific {
@@ -63,16 +117,81 @@ func (sw *SampleWriterCsv) Write(s *Sample) error {
 	alt := strconv.FormatFloat(s.Altitude, 'f', 8, 64)
 	val := strconv.FormatFloat(s.Value, mod, 8, 64)
 
-	sw.fw.Write([]string{s.Date.String(), lat, lon, alt, val, s.Unit})
+	record := []string{FormatDate(s.Date), lat, lon, alt, val, s.Unit}
+	if sw.uncertainty {
+		record = append(record, sw.formatUncertainty(s.Value, s.Uncertainty, mod)...)
+	}
+
+	for i, name := range sw.chanNames {
+		m, ok := s.Channel(name)
+		if ok {
+			record = append(record, strconv.FormatFloat(m.Value, mod, 8, 64), m.Unit)
+		} else {
+			record = append(record, "", "")
+		}
+
+		if sw.chanUncs[i] {
+			record = append(record, sw.formatUncertainty(m.Value, m.Uncertainty, mod)...)
+		}
+	}
+
+	for _, name := range sw.attrNames {
+		v, _ := s.Attributes.Get(name)
+		record = append(record, FormatAttributeValue(v))
+	}
+
+	return sw.fw.Write(record)
+}
+
+// Check that the header has a column for every value of a sample
+func (sw *SampleWriterCsv) checkColumns(s *Sample) error {
+
+	if s.Uncertainty != nil && !sw.uncertainty {
+		return errors.New("it has an uncertainty, but the csv columns are taken from the first sample, which has none")
+	}
+
+	for _, m := range s.Measurements {
+
+		i := 0
+		for i < len(sw.chanNames) && sw.chanNames[i] != m.Name {
+			i++
+		}
+
+		if i == len(sw.chanNames) {
+			return errors.New("it has measurement " + m.Name + ", but the csv columns are taken from the first sample, which has not")
+		}
+
+		if m.Uncertainty != nil && !sw.chanUncs[i] {
+			return errors.New("it has an uncertainty of " + m.Name + ", but the csv columns are taken from the first sample, which has none")
+		}
+	}
+
+	for _, attr := range s.Attributes {
+		if !containsString(sw.attrNames, attr.Name) {
+			return errors.New("it has attribute " + attr.Name + ", but the csv columns are taken from the first sample, which has not")
+		}
+	}
 
 	return nil
 }
 
+// Format an uncertainty as absolute value and coverage factor columns
+func (sw *SampleWriterCsv) formatUncertainty(value float64, u *Uncertainty, mod byte) []string {
+
+	if u == nil {
+		return []string{"", ""}
+	}
+
+	return []string{strconv.FormatFloat(u.Absolute(value), mod, 8, 64), strconv.FormatFloat(u.Coverage, 'f', -1, 64)}
+}
+
 // Close Finish the CSV file
 func (sw *SampleWriterCsv) Close() error {
 
-	sw.fw.Flush()
-	sw.fd.Close()
+	if !sw.header {
+		sw.writeHeader(nil)
+	}
 
-	return nil
+	sw.fw.Flush()
+	return sw.fw.Error()
 }
@@ -0,0 +1,84 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCsvColumns(t *testing.T) {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	first := &Sample{Date: date, Value: 0.1, Unit: "uSv/h",
+		Measurements: Measurements{{Name: "cps", Value: 12, Unit: "1/s"}},
+		Attributes:   Attributes{{Name: "detector", Value: "D1"}, {Name: "speed", Value: 2.5}}}
+
+	tests := []struct {
+		name  string
+		later *Sample
+		err   string
+	}{
+		{"fewer values", &Sample{Date: date, Value: 0.2, Unit: "uSv/h",
+			Attributes: Attributes{{Name: "speed", Value: 3.0}}}, ""},
+		{"new attribute", &Sample{Date: date, Value: 0.2, Unit: "uSv/h",
+			Attributes: Attributes{{Name: "operator", Value: "x"}}}, "Sample 2: it has attribute operator"},
+		{"new channel", &Sample{Date: date, Value: 0.2, Unit: "uSv/h",
+			Measurements: Measurements{{Name: "temp", Value: 20, Unit: "C"}}}, "Sample 2: it has measurement temp"},
+		{"new uncertainty", &Sample{Date: date, Value: 0.2, Unit: "uSv/h",
+			Uncertainty: &Uncertainty{Value: 0.01, Coverage: 2}}, "Sample 2: it has an uncertainty"},
+	}
+
+	for _, test := range tests {
+
+		var buf bytes.Buffer
+		sw, err := NewSampleWriterCsv(&buf, WriterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = sw.Write(first)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		err = sw.Write(test.later)
+		if len(test.err) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: error is %v, expected %s", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		err = sw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := "Date,Latitude,Longitude,Altitude,Value,Unit,cps,cps Unit,detector,speed\n" +
+			"2015-03-01T10:00:00Z,0.00000000,0.00000000,0.00000000,0.10000000,uSv/h,12.00000000,1/s,D1,2.5\n" +
+			"2015-03-01T10:00:00Z,0.00000000,0.00000000,0.00000000,0.20000000,uSv/h,,,,3\n"
+		if buf.String() != want {
+			t.Errorf("%s: csv is\n%s\nexpected\n%s", test.name, buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// SampleWriterGeoJSON Structure representing a sample writer
+type SampleWriterGeoJSON struct {
+	fw  *bufio.Writer
+	sep string
+}
+
+// Feature Structure representing a GeoJSON point feature
+type Feature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [3]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties Attributes `json:"properties"`
+}
+
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "geojson",
+		Description: "GeoJSON feature collection of points",
+		Extension:   ".geojson",
+		New:         NewSampleWriterGeoJSON,
+	})
+}
+
+// NewSampleWriterGeoJSON Create a new GeoJSON sample writer writing to w
+func NewSampleWriterGeoJSON(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	// Initialize a sample writer
+	sw := new(SampleWriterGeoJSON)
+	sw.sep = ""
+
+	sw.fw = bufio.NewWriter(w)
+	sw.fw.WriteString("{\n\"type\": \"FeatureCollection\",\n\"features\": [\n")
+
+	return sw, nil
+}
+
+// NewFeature Create a GeoJSON point feature from a sample. The date, values, units
+// and uncertainties of all channels and the attributes become flat properties.
+// Property names are unique, the first value for a name is kept
+func NewFeature(s *Sample) *Feature {
+
+	f := new(Feature)
+	f.Type = "Feature"
+	f.Geometry.Type = "Point"
+	f.Geometry.Coordinates = [3]float64{s.Longitude, s.Latitude, s.Altitude}
+
+	f.addProperty("date", FormatDate(s.Date))
+	f.addProperty("value", s.Value)
+	f.addProperty("unit", s.Unit)
+
+	if s.Uncertainty != nil {
+		f.addProperty("uncertainty", s.Uncertainty.Absolute(s.Value))
+		f.addProperty("coverage", s.Uncertainty.Coverage)
+	}
+
+	for _, m := range s.Measurements {
+		f.addProperty(m.Name, m.Value)
+		f.addProperty(m.Name+"_unit", m.Unit)
+
+		if m.Uncertainty != nil {
+			f.addProperty(m.Name+"_uncertainty", m.Uncertainty.Absolute(m.Value))
+			f.addProperty(m.Name+"_coverage", m.Uncertainty.Coverage)
+		}
+	}
+
+	for _, attr := range s.Attributes {
+		f.addProperty(attr.Name, attr.Value)
+	}
+
+	return f
+}
+
+// Add a property to the feature unless it has a property of the same name
+func (f *Feature) addProperty(name string, value interface{}) {
+
+	if _, ok := f.Properties.Get(name); ok {
+		return
+	}
+
+	f.Properties = append(f.Properties, Attribute{Name: name, Value: value})
+}
+
+// Write Write a sample as a feature to the geojson file
+func (sw *SampleWriterGeoJSON) Write(s *Sample) error {
+
+	b, err := json.Marshal(NewFeature(s))
+	if err != nil {
+		return err
+	}
+	sw.fw.WriteString(sw.sep + string(b))
+
+	if len(sw.sep) == 0 {
+		sw.sep = ",\n"
+	}
+
+	return nil
+}
+
+// Close Finish the geojson file
+func (sw *SampleWriterGeoJSON) Close() error {
+
+	sw.fw.WriteString("\n]\n}\n")
+	return sw.fw.Flush()
+}
@@ -0,0 +1,58 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFeatureProperties(t *testing.T) {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		sample *Sample
+		want   string
+	}{
+		{"plain", &Sample{Date: date, Value: 0.1, Unit: "uSv/h"},
+			`{"date":"2015-03-01T10:00:00Z","value":0.1,"unit":"uSv/h"}`},
+		{"attribute named like a fixed property", &Sample{Date: date, Value: 0.1, Unit: "uSv/h",
+			Attributes: Attributes{{Name: "value", Value: "x"}, {Name: "date", Value: "y"}}},
+			`{"date":"2015-03-01T10:00:00Z","value":0.1,"unit":"uSv/h"}`},
+		{"channel named like a fixed property", &Sample{Date: date, Value: 0.1, Unit: "uSv/h",
+			Uncertainty:  &Uncertainty{Value: 0.01, Coverage: 2},
+			Measurements: Measurements{{Name: "coverage", Value: 5, Unit: "%"}}},
+			`{"date":"2015-03-01T10:00:00Z","value":0.1,"unit":"uSv/h","uncertainty":0.01,"coverage":2,"coverage_unit":"%"}`},
+		{"attribute named like a channel", &Sample{Date: date, Value: 0.1, Unit: "uSv/h",
+			Measurements: Measurements{{Name: "x", Value: 5, Unit: "1/s"}},
+			Attributes:   Attributes{{Name: "x", Value: "attr"}, {Name: "x_unit", Value: "attr"}, {Name: "y", Value: true}}},
+			`{"date":"2015-03-01T10:00:00Z","value":0.1,"unit":"uSv/h","x":5,"x_unit":"1/s","y":true}`},
+	}
+
+	for _, test := range tests {
+		b, err := json.Marshal(NewFeature(test.sample).Properties)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != test.want {
+			t.Errorf("%s: properties are %s, expected %s", test.name, b, test.want)
+		}
+	}
+}
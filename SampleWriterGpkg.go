@@ -0,0 +1,595 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Statements creating the GeoPackage metadata tables and spatial reference systems
+var gpkgSchema = []string{
+	`PRAGMA application_id = 1196444487`,
+	`PRAGMA user_version = 10300`,
+	`CREATE TABLE IF NOT EXISTS gpkg_spatial_ref_sys (
+		srs_name TEXT NOT NULL,
+		srs_id INTEGER NOT NULL PRIMARY KEY,
+		organization TEXT NOT NULL,
+		organization_coordsys_id INTEGER NOT NULL,
+		definition TEXT NOT NULL,
+		description TEXT)`,
+	`INSERT OR IGNORE INTO gpkg_spatial_ref_sys VALUES
+		('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+		('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+		('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid')`,
+	`CREATE TABLE IF NOT EXISTS gpkg_contents (
+		table_name TEXT NOT NULL PRIMARY KEY,
+		data_type TEXT NOT NULL,
+		identifier TEXT UNIQUE,
+		description TEXT DEFAULT '',
+		last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+		min_x DOUBLE,
+		min_y DOUBLE,
+		max_x DOUBLE,
+		max_y DOUBLE,
+		srs_id INTEGER,
+		CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))`,
+	`CREATE TABLE IF NOT EXISTS gpkg_geometry_columns (
+		table_name TEXT NOT NULL,
+		column_name TEXT NOT NULL,
+		geometry_type_name TEXT NOT NULL,
+		srs_id INTEGER NOT NULL,
+		z TINYINT NOT NULL,
+		m TINYINT NOT NULL,
+		CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+		CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+		CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+		CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))`,
+	`CREATE TABLE IF NOT EXISTS gpkg_extensions (
+		table_name TEXT,
+		column_name TEXT,
+		extension_name TEXT NOT NULL,
+		definition TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name))`,
+}
+
+// Triggers keeping the spatial index up to date when other programs change a feature table.
+// They use the ST_ functions provided by GeoPackage aware programs, so they are dropped while
+// samples are written and created again when the writer is closed
+var gpkgRtreeTriggers = []string{
+	`CREATE TRIGGER "{rtree}_insert" AFTER INSERT ON "{table}"
+		WHEN (new.geom NOT NULL AND NOT ST_IsEmpty(NEW.geom))
+		BEGIN
+			INSERT OR REPLACE INTO "{rtree}" VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom));
+		END`,
+	`CREATE TRIGGER "{rtree}_update1" AFTER UPDATE OF geom ON "{table}"
+		WHEN OLD.fid = NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))
+		BEGIN
+			INSERT OR REPLACE INTO "{rtree}" VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom));
+		END`,
+	`CREATE TRIGGER "{rtree}_update2" AFTER UPDATE OF geom ON "{table}"
+		WHEN OLD.fid = NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))
+		BEGIN
+			DELETE FROM "{rtree}" WHERE id = OLD.fid;
+		END`,
+	`CREATE TRIGGER "{rtree}_update3" AFTER UPDATE ON "{table}"
+		WHEN OLD.fid != NEW.fid AND (NEW.geom NOTNULL AND NOT ST_IsEmpty(NEW.geom))
+		BEGIN
+			DELETE FROM "{rtree}" WHERE id = OLD.fid;
+			INSERT OR REPLACE INTO "{rtree}" VALUES (NEW.fid, ST_MinX(NEW.geom), ST_MaxX(NEW.geom), ST_MinY(NEW.geom), ST_MaxY(NEW.geom));
+		END`,
+	`CREATE TRIGGER "{rtree}_update4" AFTER UPDATE ON "{table}"
+		WHEN OLD.fid != NEW.fid AND (NEW.geom ISNULL OR ST_IsEmpty(NEW.geom))
+		BEGIN
+			DELETE FROM "{rtree}" WHERE id IN (OLD.fid, NEW.fid);
+		END`,
+	`CREATE TRIGGER "{rtree}_delete" AFTER DELETE ON "{table}"
+		WHEN old.geom NOT NULL
+		BEGIN
+			DELETE FROM "{rtree}" WHERE id = OLD.fid;
+		END`,
+}
+
+// Names of the triggers in gpkgRtreeTriggers
+var gpkgRtreeTriggerNames = []string{"insert", "update1", "update2", "update3", "update4", "delete"}
+
+// SampleWriterGpkg Structure representing a sample writer.
+// Samples are written as points to a feature table in a GeoPackage database, which is added to if it exists.
+// Columns for measurements and attributes are added to the table as they appear
+type SampleWriterGpkg struct {
+	Table   string
+	Source  string
+	db      *sql.DB
+	tx      *sql.Tx
+	rtree   string
+	columns map[string]bool
+	stmts   map[string]*sql.Stmt
+	count   int
+	minX    float64
+	minY    float64
+	maxX    float64
+	maxY    float64
+}
+
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "gpkg",
+		Description: "OGC GeoPackage with one feature table per sample file, or one combined table",
+		Extension:   ".gpkg",
+		Options:     []string{OptionDatabase, OptionTable},
+		WritesFile:  true,
+		New:         NewSampleWriterGpkg,
+	})
+}
+
+// NewSampleWriterGpkg Create a new GeoPackage sample writer writing to the database in the OutputFile option.
+// Without the Table option, the samples are written to a table named after the Source option,
+// replacing the table if the source has been converted before. Otherwise they are added to the given table
+func NewSampleWriterGpkg(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	if len(opts.OutputFile) == 0 {
+		return nil, errors.New("The gpkg format needs an output file")
+	}
+
+	// Initialize a sample writer
+	sw := new(SampleWriterGpkg)
+	sw.Source = opts.Source
+	sw.Table = opts.Table
+	sw.columns = make(map[string]bool)
+	sw.stmts = make(map[string]*sql.Stmt)
+
+	replace := len(sw.Table) == 0
+	if replace {
+		sw.Table = gpkgTableName(sw.Source)
+	}
+	sw.rtree = "rtree_" + sw.Table + "_geom"
+
+	var err error
+	sw.db, err = sql.Open("sqlite", opts.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// All samples are written in a single transaction
+	sw.db.SetMaxOpenConns(1)
+
+	sw.tx, err = sw.db.Begin()
+	if err != nil {
+		sw.db.Close()
+		return nil, err
+	}
+
+	err = sw.createTable(replace)
+	if err != nil {
+		sw.tx.Rollback()
+		sw.db.Close()
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+// Create the metadata tables if needed, and create or prepare the feature table and its spatial index
+func (sw *SampleWriterGpkg) createTable(replace bool) error {
+
+	for _, stmt := range gpkgSchema {
+		_, err := sw.tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if replace {
+		err := sw.dropTable()
+		if err != nil {
+			return err
+		}
+	}
+
+	var n int
+	err := sw.tx.QueryRow(`SELECT count(*) FROM gpkg_contents WHERE table_name = ?`, sw.Table).Scan(&n)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+
+		// Add to the existing table, the spatial index is maintained by the writer until it is closed
+		for _, name := range gpkgRtreeTriggerNames {
+			_, err = sw.tx.Exec(`DROP TRIGGER IF EXISTS ` + quoteIdent(sw.rtree+"_"+name))
+			if err != nil {
+				return err
+			}
+		}
+
+		return sw.loadColumns()
+	}
+
+	stmts := []string{
+		`CREATE TABLE ` + quoteIdent(sw.Table) + ` (
+			fid INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			geom POINT,
+			source TEXT,
+			date DATETIME,
+			altitude DOUBLE,
+			value DOUBLE,
+			unit TEXT,
+			uncertainty DOUBLE,
+			coverage DOUBLE)`,
+		`CREATE VIRTUAL TABLE ` + quoteIdent(sw.rtree) + ` USING rtree(id, minx, maxx, miny, maxy)`,
+	}
+
+	for _, stmt := range stmts {
+		_, err = sw.tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = sw.tx.Exec(`INSERT INTO gpkg_contents (table_name, data_type, identifier, description, srs_id) VALUES (?, 'features', ?, ?, 4326)`,
+		sw.Table, sw.Table, "Samples converted by SampleConverter")
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.tx.Exec(`INSERT INTO gpkg_geometry_columns VALUES (?, 'geom', 'POINT', 4326, 0, 0)`, sw.Table)
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.tx.Exec(`INSERT INTO gpkg_extensions VALUES (?, 'geom', 'gpkg_rtree_index', 'http://www.geopackage.org/spec120/#extension_rtree', 'write-only')`, sw.Table)
+	if err != nil {
+		return err
+	}
+
+	return sw.loadColumns()
+}
+
+// Drop a feature table, its spatial index and its metadata
+func (sw *SampleWriterGpkg) dropTable() error {
+
+	stmts := []string{
+		`DROP TABLE IF EXISTS ` + quoteIdent(sw.rtree),
+		`DROP TABLE IF EXISTS ` + quoteIdent(sw.Table),
+		`DELETE FROM gpkg_extensions WHERE table_name = ?`,
+		`DELETE FROM gpkg_geometry_columns WHERE table_name = ?`,
+		`DELETE FROM gpkg_contents WHERE table_name = ?`,
+	}
+
+	for _, stmt := range stmts {
+
+		var err error
+		if strings.Contains(stmt, "?") {
+			_, err = sw.tx.Exec(stmt, sw.Table)
+		} else {
+			_, err = sw.tx.Exec(stmt)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load the names of the columns in the feature table
+func (sw *SampleWriterGpkg) loadColumns() error {
+
+	rows, err := sw.tx.Query(`PRAGMA table_info(` + quoteIdent(sw.Table) + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var cid int
+		var name, typ string
+		var notNull, pk int
+		var dflt interface{}
+
+		err = rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk)
+		if err != nil {
+			return err
+		}
+
+		sw.columns[strings.ToLower(name)] = true
+	}
+
+	return rows.Err()
+}
+
+// Add a column to the feature table unless it exists
+func (sw *SampleWriterGpkg) addColumn(name, typ string) error {
+
+	if sw.columns[strings.ToLower(name)] {
+		return nil
+	}
+
+	_, err := sw.tx.Exec(`ALTER TABLE ` + quoteIdent(sw.Table) + ` ADD COLUMN ` + quoteIdent(name) + ` ` + typ)
+	if err != nil {
+		return err
+	}
+
+	sw.columns[strings.ToLower(name)] = true
+
+	return nil
+}
+
+// Write Write a sample to the feature table
+func (sw *SampleWriterGpkg) Write(s *Sample) error {
+
+	row := newGpkgRow(gpkgPoint(s.Longitude, s.Latitude))
+	row.add("source", "TEXT", sampleSource(s, sw.Source))
+	row.add("date", "DATETIME", s.Date.UTC().Format("2006-01-02T15:04:05.000Z"))
+	row.add("altitude", "DOUBLE", s.Altitude)
+	row.add("value", "DOUBLE", s.Value)
+	row.add("unit", "TEXT", s.Unit)
+
+	if s.Uncertainty != nil {
+		row.add("uncertainty", "DOUBLE", s.Uncertainty.Absolute(s.Value))
+		row.add("coverage", "DOUBLE", s.Uncertainty.Coverage)
+	}
+
+	for _, m := range s.Measurements {
+		row.add(m.Name, "DOUBLE", m.Value)
+		row.add(m.Name+"_unit", "TEXT", m.Unit)
+		if m.Uncertainty != nil {
+			row.add(m.Name+"_uncertainty", "DOUBLE", m.Uncertainty.Absolute(m.Value))
+			row.add(m.Name+"_coverage", "DOUBLE", m.Uncertainty.Coverage)
+		}
+	}
+
+	for _, a := range s.Attributes {
+		switch v := a.Value.(type) {
+		case float64:
+			row.add(a.Name, "DOUBLE", v)
+		case bool:
+			row.add(a.Name, "BOOLEAN", v)
+		case nil:
+			// Missing attribute values are left as NULL
+		default:
+			row.add(a.Name, "TEXT", FormatAttributeValue(v))
+		}
+	}
+
+	// Make sure the table has columns for all measurements and attributes
+	for i, name := range row.names {
+		err := sw.addColumn(name, row.types[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt, err := sw.insertStmt(row.names)
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(row.values...)
+	if err != nil {
+		return err
+	}
+
+	fid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.tx.Exec(`INSERT INTO `+quoteIdent(sw.rtree)+` VALUES (?, ?, ?, ?, ?)`, fid, s.Longitude, s.Longitude, s.Latitude, s.Latitude)
+	if err != nil {
+		return err
+	}
+
+	// Update the extent of the table
+	if sw.count == 0 {
+		sw.minX, sw.maxX = s.Longitude, s.Longitude
+		sw.minY, sw.maxY = s.Latitude, s.Latitude
+	} else {
+		sw.minX = math.Min(sw.minX, s.Longitude)
+		sw.maxX = math.Max(sw.maxX, s.Longitude)
+		sw.minY = math.Min(sw.minY, s.Latitude)
+		sw.maxY = math.Max(sw.maxY, s.Latitude)
+	}
+	sw.count++
+
+	return nil
+}
+
+// Get a prepared insert statement for the given columns
+func (sw *SampleWriterGpkg) insertStmt(names []string) (*sql.Stmt, error) {
+
+	columns := make([]string, len(names))
+	for i, name := range names {
+		columns[i] = quoteIdent(name)
+	}
+
+	query := `INSERT INTO ` + quoteIdent(sw.Table) + ` (` + strings.Join(columns, ", ") + `) VALUES (?` + strings.Repeat(", ?", len(names)-1) + `)`
+
+	stmt, ok := sw.stmts[query]
+	if ok {
+		return stmt, nil
+	}
+
+	stmt, err := sw.tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	sw.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+// Abort Roll back the samples written, leaving the database as it was before the writer was created
+func (sw *SampleWriterGpkg) Abort() error {
+
+	err := sw.tx.Rollback()
+	if err != nil {
+		sw.db.Close()
+		return err
+	}
+
+	return sw.db.Close()
+}
+
+// Close Update the table metadata, restore the spatial index triggers and commit the samples to the database
+func (sw *SampleWriterGpkg) Close() error {
+
+	err := sw.finish()
+	if err != nil {
+		sw.tx.Rollback()
+		sw.db.Close()
+		return err
+	}
+
+	err = sw.tx.Commit()
+	if err != nil {
+		sw.db.Close()
+		return err
+	}
+
+	return sw.db.Close()
+}
+
+// Update the extent of the table and create the spatial index triggers
+func (sw *SampleWriterGpkg) finish() error {
+
+	_, err := sw.tx.Exec(`UPDATE gpkg_contents SET last_change = ? WHERE table_name = ?`,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), sw.Table)
+	if err != nil {
+		return err
+	}
+
+	if sw.count > 0 {
+		_, err = sw.tx.Exec(`UPDATE gpkg_contents SET
+			min_x = min(coalesce(min_x, ?), ?), min_y = min(coalesce(min_y, ?), ?),
+			max_x = max(coalesce(max_x, ?), ?), max_y = max(coalesce(max_y, ?), ?)
+			WHERE table_name = ?`,
+			sw.minX, sw.minX, sw.minY, sw.minY, sw.maxX, sw.maxX, sw.maxY, sw.maxY, sw.Table)
+		if err != nil {
+			return err
+		}
+	}
+
+	r := strings.NewReplacer("{table}", strings.Replace(sw.Table, `"`, `""`, -1), "{rtree}", strings.Replace(sw.rtree, `"`, `""`, -1))
+	for _, trigger := range gpkgRtreeTriggers {
+		_, err = sw.tx.Exec(r.Replace(trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Columns of the feature table that hold the feature id and the geometry
+var gpkgReservedColumns = []string{"fid", "geom"}
+
+// Structure representing the columns and values of a feature table row
+type gpkgRow struct {
+	names  []string
+	types  []string
+	values []interface{}
+}
+
+// Create a feature table row with a geometry
+func newGpkgRow(geom []byte) *gpkgRow {
+
+	row := new(gpkgRow)
+	row.names = append(row.names, "geom")
+	row.types = append(row.types, "POINT")
+	row.values = append(row.values, geom)
+
+	return row
+}
+
+// Add a column to the row. Column names are case insensitive, and the first value for a name is kept.
+// Names of the reserved columns are prefixed with "sample_", so they can not replace the feature id or geometry
+func (row *gpkgRow) add(name, typ string, value interface{}) {
+
+	for _, r := range gpkgReservedColumns {
+		if strings.EqualFold(r, name) {
+			name = "sample_" + name
+			break
+		}
+	}
+
+	for _, n := range row.names {
+		if strings.EqualFold(n, name) {
+			return
+		}
+	}
+
+	row.names = append(row.names, name)
+	row.types = append(row.types, typ)
+	row.values = append(row.values, value)
+}
+
+// Encode a point as a GeoPackage geometry, a header without envelope followed by a little endian WKB point
+func gpkgPoint(x, y float64) []byte {
+
+	b := make([]byte, 29)
+
+	copy(b, "GP")
+	b[2] = 0 // Version 1
+	b[3] = 1 // Little endian, no envelope
+	binary.LittleEndian.PutUint32(b[4:], 4326)
+
+	b[8] = 1 // Little endian
+	binary.LittleEndian.PutUint32(b[9:], 1)
+	binary.LittleEndian.PutUint64(b[13:], math.Float64bits(x))
+	binary.LittleEndian.PutUint64(b[21:], math.Float64bits(y))
+
+	return b
+}
+
+// Make a table name from the name of a sample file
+func gpkgTableName(source string) string {
+
+	if len(source) == 0 {
+		return "samples"
+	}
+
+	name := []rune(strings.ToLower(source))
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+
+	if name[0] >= '0' && name[0] <= '9' {
+		return "t_" + string(name)
+	}
+
+	return string(name)
+}
+
+// Quote an SQL identifier
+func quoteIdent(name string) string {
+
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,95 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGpkgPoint(t *testing.T) {
+
+	x, y := 10.7, -59.9
+	b := gpkgPoint(x, y)
+
+	// GeoPackage binary header: magic, version, flags and srs id
+	if len(b) != 8+21 {
+		t.Fatalf("blob is %d bytes, expected %d", len(b), 8+21)
+	}
+
+	if string(b[0:2]) != "GP" || b[2] != 0 {
+		t.Errorf("header starts with %q version %d, expected \"GP\" version 0", b[0:2], b[2])
+	}
+
+	// Little endian, no envelope, not empty, standard geometry
+	if b[3] != 0x01 {
+		t.Errorf("flags are %08b, expected 00000001", b[3])
+	}
+
+	if srs := binary.LittleEndian.Uint32(b[4:]); srs != 4326 {
+		t.Errorf("srs id is %d, expected 4326", srs)
+	}
+
+	// Well-known binary point
+	wkb := b[8:]
+	if wkb[0] != 1 {
+		t.Errorf("wkb byte order is %d, expected 1", wkb[0])
+	}
+
+	if typ := binary.LittleEndian.Uint32(wkb[1:]); typ != 1 {
+		t.Errorf("wkb geometry type is %d, expected 1", typ)
+	}
+
+	gx := math.Float64frombits(binary.LittleEndian.Uint64(wkb[5:]))
+	gy := math.Float64frombits(binary.LittleEndian.Uint64(wkb[13:]))
+	if gx != x || gy != y {
+		t.Errorf("point is %v %v, expected %v %v", gx, gy, x, y)
+	}
+}
+
+func TestGpkgRowReservedColumns(t *testing.T) {
+
+	tests := []struct {
+		add   []string
+		names []string
+	}{
+		{[]string{"value", "Value"}, []string{"geom", "value"}},
+		{[]string{"fid", "geom"}, []string{"geom", "sample_fid", "sample_geom"}},
+		{[]string{"FID", "Geom"}, []string{"geom", "sample_FID", "sample_Geom"}},
+		{[]string{"geom", "sample_geom"}, []string{"geom", "sample_geom"}},
+		{[]string{"fid_unit", "geometry"}, []string{"geom", "fid_unit", "geometry"}},
+	}
+
+	geom := gpkgPoint(1, 2)
+
+	for _, test := range tests {
+
+		row := newGpkgRow(geom)
+		for i, name := range test.add {
+			row.add(name, "DOUBLE", float64(i))
+		}
+
+		if !reflect.DeepEqual(row.names, test.names) {
+			t.Errorf("%v: columns are %v, expected %v", test.add, row.names, test.names)
+		}
+
+		if !reflect.DeepEqual(row.values[0], geom) {
+			t.Errorf("%v: geometry was replaced by %v", test.add, row.values[0])
+		}
+	}
+}
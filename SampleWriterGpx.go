@@ -0,0 +1,208 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// Namespace of the sample values in gpx extensions
+const gpxNamespace = "https://github.com/bytting/SampleConverter"
+
+// SampleWriterGpx Structure representing a sample writer
+type SampleWriterGpx struct {
+	Name          string
+	Source        string
+	UseScientific bool
+	UseWaypoints  bool
+	fw            *bufio.Writer
+	track         string // Name of the open track
+	inTrack       bool
+}
+
+// GpxPoint Structure representing a gpx track point or waypoint
+type GpxPoint struct {
+	XMLName    xml.Name
+	Lat        float64       `xml:"lat,attr"`
+	Lon        float64       `xml:"lon,attr"`
+	Ele        float64       `xml:"ele"`
+	Time       string        `xml:"time"`
+	Name       string        `xml:"name,omitempty"`
+	Extensions GpxExtensions `xml:"extensions"`
+}
+
+// GpxExtensions Structure representing the sample values of a gpx point
+type GpxExtensions struct {
+	Value struct {
+		Unit  string `xml:"unit,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"sc:value"`
+	Uncertainty  *GpxUncertainty  `xml:"sc:uncertainty,omitempty"`
+	Measurements []GpxMeasurement `xml:"sc:measurement"`
+	Attributes   []GpxAttribute   `xml:"sc:attribute"`
+}
+
+// GpxUncertainty Structure representing an absolute uncertainty in gpx extensions
+type GpxUncertainty struct {
+	Coverage float64 `xml:"coverage,attr"`
+	Value    string  `xml:",chardata"`
+}
+
+// GpxMeasurement Structure representing a measurement channel in gpx extensions
+type GpxMeasurement struct {
+	Name  string `xml:"name,attr"`
+	Unit  string `xml:"unit,attr"`
+	Value string `xml:",chardata"`
+}
+
+// GpxAttribute Structure representing a sample attribute in gpx extensions
+type GpxAttribute struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "gpx",
+		Description: "GPX track, or waypoints, with sample values as extensions",
+		Extension:   ".gpx",
+		Options:     []string{OptionScientific, OptionWaypoints},
+		New:         NewSampleWriterGpx,
+	})
+}
+
+// NewSampleWriterGpx Create a new GPX sample writer writing to w.
+// The samples are written as a track for each sample file, or as waypoints with the UseWaypoints option
+func NewSampleWriterGpx(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	// Initialize a sample writer
+	sw := new(SampleWriterGpx)
+	sw.Name = opts.Name
+	sw.Source = opts.Source
+	if len(sw.Source) == 0 {
+		sw.Source = sw.Name
+	}
+	sw.UseScientific = opts.UseScientific
+	sw.UseWaypoints = opts.UseWaypoints
+
+	sw.fw = bufio.NewWriter(w)
+	sw.fw.WriteString(xml.Header)
+	sw.fw.WriteString("<gpx version=\"1.1\" creator=\"SampleConverter\" xmlns=\"http://www.topografix.com/GPX/1/1\" xmlns:sc=\"" + gpxNamespace + "\">\n")
+
+	return sw, nil
+}
+
+// Start a new track unless the open track has the given name. Merged sample files
+// get a track each, named by their source attribute
+func (sw *SampleWriterGpx) beginTrack(name string) error {
+
+	if sw.inTrack && name == sw.track {
+		return nil
+	}
+
+	if sw.inTrack {
+		sw.fw.WriteString("    </trkseg>\n  </trk>\n")
+	}
+
+	b, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"name"`
+		Name    string   `xml:",chardata"`
+	}{Name: name})
+	if err != nil {
+		return err
+	}
+
+	sw.fw.WriteString("  <trk>\n    " + string(b) + "\n    <trkseg>\n")
+	sw.track = name
+	sw.inTrack = true
+
+	return nil
+}
+
+// Write Write a sample as a track point or waypoint to the gpx file
+func (sw *SampleWriterGpx) Write(s *Sample) error {
+
+	var p GpxPoint
+
+	// Set the number format
+	mod := byte('f')
+	if sw.UseScientific {
+		mod = byte('E')
+	}
+
+	val := strconv.FormatFloat(s.Value, mod, -1, 64)
+
+	p.Lat = s.Latitude
+	p.Lon = s.Longitude
+	p.Ele = s.Altitude
+	p.Time = FormatDate(s.Date.UTC())
+
+	p.Extensions.Value.Value = val
+	p.Extensions.Value.Unit = s.Unit
+
+	if s.Uncertainty != nil {
+		p.Extensions.Uncertainty = &GpxUncertainty{
+			Coverage: s.Uncertainty.Coverage,
+			Value:    strconv.FormatFloat(s.Uncertainty.Absolute(s.Value), mod, -1, 64),
+		}
+	}
+
+	for _, m := range s.Measurements {
+		p.Extensions.Measurements = append(p.Extensions.Measurements,
+			GpxMeasurement{Name: m.Name, Unit: m.Unit, Value: strconv.FormatFloat(m.Value, mod, -1, 64)})
+	}
+
+	for _, attr := range s.Attributes {
+		p.Extensions.Attributes = append(p.Extensions.Attributes,
+			GpxAttribute{Name: attr.Name, Value: FormatAttributeValue(attr.Value)})
+	}
+
+	indent := "      "
+	if sw.UseWaypoints {
+		p.XMLName.Local = "wpt"
+		p.Name = val + " " + s.Unit
+		indent = "  "
+	} else {
+		err := sw.beginTrack(sampleSource(s, sw.Source))
+		if err != nil {
+			return err
+		}
+		p.XMLName.Local = "trkpt"
+	}
+
+	b, err := xml.MarshalIndent(p, indent, "  ")
+	if err != nil {
+		return err
+	}
+	sw.fw.WriteString(string(b) + "\n")
+
+	return nil
+}
+
+// Close Finish the gpx file
+func (sw *SampleWriterGpx) Close() error {
+
+	if sw.inTrack {
+		sw.fw.WriteString("    </trkseg>\n  </trk>\n")
+	}
+
+	sw.fw.WriteString("</gpx>\n")
+	return sw.fw.Flush()
+}
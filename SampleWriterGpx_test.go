@@ -0,0 +1,97 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGpxTracks(t *testing.T) {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+	sample := func(source string) *Sample {
+
+		s := &Sample{Date: date, Latitude: 59.9, Longitude: 10.7, Value: 0.1, Unit: "uSv/h"}
+		if len(source) > 0 {
+			s.Attributes = Attributes{{Name: SourceAttribute, Value: source}}
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		opts    WriterOptions
+		samples []*Sample
+		want    []string // Track names, with a letter for each track point
+	}{
+		{"single file", WriterOptions{Name: "survey.gpx", Source: "survey.log"},
+			[]*Sample{sample(""), sample("")}, []string{"survey.log pp"}},
+		{"stream", WriterOptions{Name: "stdin.gpx"},
+			[]*Sample{sample("")}, []string{"stdin.gpx p"}},
+		{"merged files", WriterOptions{Name: "campaign.gpx"},
+			[]*Sample{sample("day1.log"), sample("day1.log"), sample("day2.log")}, []string{"day1.log pp", "day2.log p"}},
+	}
+
+	for _, test := range tests {
+
+		var buf bytes.Buffer
+		sw, err := NewSampleWriterGpx(&buf, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range test.samples {
+			err = sw.Write(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = sw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var gpx struct {
+			Tracks []struct {
+				Name   string     `xml:"name"`
+				Points []struct{} `xml:"trkseg>trkpt"`
+			} `xml:"trk"`
+		}
+
+		err = xml.Unmarshal(buf.Bytes(), &gpx)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		var got []string
+		for _, trk := range gpx.Tracks {
+			points := ""
+			for range trk.Points {
+				points += "p"
+			}
+			got = append(got, trk.Name+" "+points)
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: tracks are %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
@@ -13,106 +13,172 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
 	"archive/zip"
 	"bufio"
-	"encoding/base64"
 	"encoding/xml"
-	"io/ioutil"
-	"os"
-	"path"
-	"path/filepath"
+	"errors"
+	"io"
 	"strconv"
-	"strings"
 )
 
 //const validChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_().,"
 
+// Default color scale of the irix writer, fixed dose rate classes in Sv/h
+var irixColorScale = ColorScale{Breaks: []float64{1, 5, 10, 20}, Classes: 5, Binning: BinningLinear, Palette: "irix"}
+
 // SampleWriterIrix Structure representing a sample writer
 type SampleWriterIrix struct {
-	KmlFile       string
-	KmzFile       string
+	Name          string
 	UseScientific bool
 	UseLabels     bool
-	fd            *os.File
+	Channel       string
+	ColorScale    ColorScale
+	Folders       string
+	Source        string
+	w             io.Writer
+	zw            *zip.Writer
 	fw            *bufio.Writer
+	spool         *SampleSpool
+	classes       *ColorClasses
+	folders       *kmlFolders
 }
 
-// NewSampleWriterIrix Create a new sample writer
-func NewSampleWriterIrix(kmzFile string, useScientific, useLabels bool) (SampleWriter, error) {
+func init() {
+
+	RegisterFormat(Format{
+		Name:         "irix-kmz",
+		Description:  "Google Earth placemarks colored by IRIX dose rate classes (Sv/h)",
+		Extension:    ".irix.kmz",
+		Options:      append([]string{OptionScientific, OptionLabels, OptionChannel, OptionFolders}, ColorScaleOptions...),
+		SanitizeName: true,
+		New:          NewSampleWriterIrix,
+	})
+}
+
+// NewSampleWriterIrix Create a new sample writer writing a kmz archive to w.
+// The name option is used for the kml file inside the archive and in placemark descriptions
+func NewSampleWriterIrix(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	err := validFolders(opts.Folders)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := opts.ColorScale.WithDefaults(irixColorScale)
+	err = scale.Validate()
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize a sample writer
 	sw := new(SampleWriterIrix)
+	sw.Name = SafeFileName(opts.Name)
+	sw.UseScientific = opts.UseScientific
+	sw.UseLabels = opts.UseLabels
+	sw.Channel = opts.Channel
+	sw.ColorScale = scale
+	sw.Folders = opts.Folders
+	sw.Source = opts.Source
+	if len(sw.Source) == 0 {
+		sw.Source = sw.Name
+	}
+	sw.w = w
 
-	// Replace local characters from basename. Google Earth doesn't like them
-	base := filepath.Base(kmzFile)
-	newBase := ""
-	for _, r := range base {
-		if !strings.ContainsAny(validChars, string(r)) {
-			newBase += "_"
-		} else {
-			newBase += string(r)
+	// Classes computed from the values of the file, and folders by class, need all samples
+	// before any output, so the samples are spooled and the kml file is written on Close
+	if scale.NeedsValues() || sw.Folders == FoldersClass {
+		sw.spool, err = NewSampleSpool(sw.Channel)
+		if err != nil {
+			return nil, err
 		}
+		return sw, nil
 	}
-	sw.KmzFile = filepath.Join(filepath.Dir(kmzFile), string(os.PathSeparator), newBase)
 
-	ext := filepath.Ext(sw.KmzFile)
-	sw.KmlFile = strings.TrimSuffix(sw.KmzFile, ext) + ".kml"
-	sw.UseScientific = useScientific
-	sw.UseLabels = useLabels
+	// With fixed classes, the kml file is streamed directly into the archive
+	sw.classes, err = scale.Resolve(0, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	var err error
-	sw.fd, err = os.Create(sw.KmlFile)
+	err = sw.begin()
 	if err != nil {
 		return nil, err
 	}
 
-	sw.fw = bufio.NewWriter(sw.fd)
+	return sw, nil
+}
+
+// Create the kml file in the archive and add styles to it
+func (sw *SampleWriterIrix) begin() error {
+
+	sw.zw = zip.NewWriter(sw.w)
+
+	z, err := sw.zw.Create(kmlFileName(sw.Name))
+	if err != nil {
+		return err
+	}
+
+	sw.fw = bufio.NewWriter(z)
+
+	mod := byte('f')
+	if sw.UseScientific {
+		mod = byte('E')
+	}
+
+	sw.folders = &kmlFolders{
+		Grouping: sw.Folders,
+		Source:   sw.Source,
+		Channel:  sw.Channel,
+		Unit:     "Sv/h",
+		Mod:      mod,
+		Classes:  sw.classes,
+		fw:       sw.fw,
+	}
 
 	// Add styles to the kml file
 	var s Style
 	sw.fw.WriteString(xml.Header)
 	sw.fw.WriteString("\n<kml>\n  <Document>\n")
 
-	colors := [...]string{"FFF0AA14", "FF78FFB4", "FF78FFF0", "FF14B4FF", "FF143CFF"}
-
-	for i := 0; i < 5; i++ {
+	for i, color := range sw.classes.Colors {
 		s.ID = strconv.Itoa(i)
 		s.IconStyle.Icon.Href = "files/donut.png"
 		s.IconStyle.Scale = "1.0"
-		s.IconStyle.Color = colors[i]
+		s.IconStyle.Color = color
 		s.LabelStyle.Scale = "1.0"
 		b, err := xml.MarshalIndent(s, "    ", "    ")
 		if err != nil {
-			sw.fd.Close()
-			os.Remove(sw.KmlFile)
-			return nil, err
+			return err
 		}
 		sw.fw.WriteString(string(b) + "\n")
 	}
 
-	return sw, nil
+	return writeLegendOverlay(sw.fw)
 }
 
-// Write Write a sample to the kml file
+// Write Write a sample to the kml file, or to the spool when the classes depend on the values of the file
 func (sw *SampleWriterIrix) Write(s *Sample) error {
 
+	if sw.spool != nil {
+		return sw.spool.Write(s)
+	}
+
+	return sw.folders.Write(s, sw.writePlacemark)
+}
+
+// Write a sample as a placemark to the kml file
+func (sw *SampleWriterIrix) writePlacemark(s *Sample) error {
+
 	var p Placemark
-	var styleID int
 
-	// Calculate the style id for this sample
-	if s.Value <= 1 {
-		styleID = 0
-	} else if s.Value <= 5 {
-		styleID = 1
-	} else if s.Value <= 10 {
-		styleID = 2
-	} else if s.Value <= 20 {
-		styleID = 3
-	} else {
-		styleID = 4
+	// The selected channel decides the color and label of the placemark.
+	// The irix classes are dose rates in Sv/h, so the channel must hold dose rates
+	m, ok := s.Channel(sw.Channel)
+	if !ok {
+		return errors.New("Sample has no measurement " + sw.Channel)
 	}
 
 	// Set the number format
@@ -123,17 +189,19 @@ func (sw *SampleWriterIrix) Write(s *Sample) error {
 
 	// Initialize a placemark structure
 	if sw.UseLabels {
-		p.Name = strconv.FormatFloat(s.Value, mod, -1, 64) + " Sv/h"
+		p.Name = strconv.FormatFloat(m.Value, mod, -1, 64) + " Sv/h"
 	}
-	p.StyleURL = "#" + strconv.Itoa(styleID)
-	p.TimeStamp.When = s.Date.Format("2006-01-02T15:04:05")
+	p.StyleURL = "#" + strconv.Itoa(sw.classes.Class(m.Value))
+	p.TimeStamp.When = FormatDate(s.Date)
 	p.Point.Coordinates = strconv.FormatFloat(s.Longitude, 'f', -1, 64) + "," +
 		strconv.FormatFloat(s.Latitude, 'f', -1, 64)
-	p.Description = "Value: " + strconv.FormatFloat(s.Value, mod, -1, 64) + " Sv/h" +
+	p.Description = "Value: " + strconv.FormatFloat(s.Value, mod, -1, 64) + " Sv/h" + uncertaintyDescription(s.Uncertainty, "Sv/h", mod) +
 		"\nLatitude: " + strconv.FormatFloat(s.Latitude, 'f', -1, 64) +
 		"\nLongitude: " + strconv.FormatFloat(s.Longitude, 'f', -1, 64) +
 		"\nAltitude: " + strconv.FormatFloat(s.Altitude, 'f', -1, 64) +
-		"\nTime: " + s.Date.String() + "\nFile: " + filepath.Base(sw.KmzFile)
+		"\nTime: " + FormatDate(s.Date) + "\nFile: " + sw.Name +
+		measurementDescription(s.Measurements, mod) + attributeDescription(s.Attributes)
+	p.ExtendedData = NewExtendedData(s.Attributes)
 
 	// Write placemark structure to the kml file
 	b, err := xml.MarshalIndent(p, "    ", "    ")
@@ -145,67 +213,72 @@ func (sw *SampleWriterIrix) Write(s *Sample) error {
 	return nil
 }
 
-// Close Finish the kml file and zip it to make a kmz file
-func (sw *SampleWriterIrix) Close() error {
-
-	sw.fw.WriteString("  </Document>\n</kml>")
-	sw.fw.Flush()
-	sw.fd.Close()
+// Abort Discard the spooled samples, if any, without finishing the kmz archive
+func (sw *SampleWriterIrix) Abort() error {
 
-	err := sw.zipKml()
-	if err != nil {
-		return err
+	if sw.spool != nil {
+		return sw.spool.Close()
 	}
 
-	os.Remove(sw.KmlFile)
-
 	return nil
 }
 
-// Zip the kml file
-func (sw *SampleWriterIrix) zipKml() error {
+// Close Finish the kml file and the kmz archive
+func (sw *SampleWriterIrix) Close() error {
 
-	// Create kmz file
-	zfout, err := os.Create(sw.KmzFile)
-	if err != nil {
-		return err
+	if sw.spool != nil {
+		err := sw.writeSpool()
+		if err != nil {
+			return err
+		}
 	}
-	defer zfout.Close()
 
-	zw := zip.NewWriter(zfout)
-	defer zw.Close() // FIXME: error checking
+	sw.folders.Close()
 
-	kmlFileBase := path.Base(filepath.ToSlash(sw.KmlFile))
-
-	z, err := zw.Create(kmlFileBase)
+	sw.fw.WriteString("  </Document>\n</kml>")
+	err := sw.fw.Flush()
 	if err != nil {
 		return err
 	}
 
-	b, err := ioutil.ReadFile(sw.KmlFile)
+	err = addDonut(sw.zw)
 	if err != nil {
 		return err
 	}
 
-	_, err = z.Write(b)
+	err = addLegend(sw.zw, sw.classes, "Dose rate", sw.folders.Unit, sw.folders.Mod)
 	if err != nil {
 		return err
 	}
 
-	z, err = zw.Create("files/donut.png")
-	if err != nil {
-		return err
+	return sw.zw.Close()
+}
+
+// Compute the classes from the spooled samples and write them to the kml file
+func (sw *SampleWriterIrix) writeSpool() error {
+
+	defer sw.spool.Close()
+
+	// Quantile binning needs all the values of the selected channel
+	var values []float64
+	if sw.ColorScale.Binning == BinningQuantile {
+		var err error
+		values, err = sw.spool.SortedValues()
+		if err != nil {
+			return err
+		}
 	}
 
-	b, err = base64.StdEncoding.DecodeString(PngDonut)
+	var err error
+	sw.classes, err = sw.ColorScale.Resolve(sw.spool.MinValue, sw.spool.MaxValue, sw.spool.MinAbove, values)
 	if err != nil {
 		return err
 	}
 
-	_, err = z.Write(b)
+	err = sw.begin()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return sw.folders.WriteSpool(sw.spool, sw.writePlacemark)
 }
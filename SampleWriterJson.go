@@ -13,37 +13,38 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
 	"bufio"
 	"encoding/json"
-	"os"
+	"io"
 )
 
 // SampleWriterJSON Structure representing a sample writer
 type SampleWriterJSON struct {
-	jsonFile string
-	fd       *os.File
-	fw       *bufio.Writer
-	sep      string
+	fw  *bufio.Writer
+	sep string
 }
 
-// NewSampleWriterJSON Create a new JSON sample writer
-func NewSampleWriterJSON(jsonFile string) (SampleWriter, error) {
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "json",
+		Description: "JSON array of samples",
+		Extension:   ".json",
+		New:         NewSampleWriterJSON,
+	})
+}
+
+// NewSampleWriterJSON Create a new JSON sample writer writing to w
+func NewSampleWriterJSON(w io.Writer, opts WriterOptions) (SampleWriter, error) {
 
 	// Initialize a sample writer
 	sw := new(SampleWriterJSON)
-	sw.jsonFile = jsonFile
 	sw.sep = ""
 
-	var err error
-	sw.fd, err = os.Create(sw.jsonFile)
-	if err != nil {
-		return nil, err
-	}
-
-	sw.fw = bufio.NewWriter(sw.fd)
+	sw.fw = bufio.NewWriter(w)
 	sw.fw.WriteString("[\n")
 
 	return sw, nil
@@ -69,8 +70,5 @@ func (sw *SampleWriterJSON) Write(s *Sample) error {
 func (sw *SampleWriterJSON) Close() error {
 
 	sw.fw.WriteString("\n]")
-	sw.fw.Flush()
-	sw.fd.Close()
-
-	return nil
+	return sw.fw.Flush()
 }
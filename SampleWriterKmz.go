@@ -13,16 +13,15 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
 	"archive/zip"
 	"bufio"
 	"encoding/base64"
 	"encoding/xml"
-	"io/ioutil"
-	"os"
-	"path"
+	"errors"
+	"io"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -30,16 +29,26 @@ import (
 
 const validChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_().,"
 
+// Default color scale of the kmz writer, four linear classes over the value range of the file
+var kmzColorScale = ColorScale{Classes: 4, Binning: BinningLinear, Palette: "kmz"}
+
 // SampleWriterKmz Structure representing a sample writer
 type SampleWriterKmz struct {
-	KmlFile       string
-	KmzFile       string
+	Name          string
 	MinValue      float64
 	MaxValue      float64
 	UseScientific bool
 	UseLabels     bool
-	fd            *os.File
+	Channel       string
+	Track         string
+	AltitudeMode  string
+	ColorScale    ColorScale
+	Folders       string
+	Source        string
+	w             io.Writer
 	fw            *bufio.Writer
+	spool         *SampleSpool
+	classes       *ColorClasses
 }
 
 // Style Structure representing a kml style
@@ -55,6 +64,13 @@ type Style struct {
 	LabelStyle struct {
 		Scale string `xml:"scale"`
 	}
+	LineStyle *LineStyle `xml:"LineStyle,omitempty"`
+}
+
+// LineStyle Structure representing a kml line style
+type LineStyle struct {
+	Color string `xml:"color"`
+	Width string `xml:"width"`
 }
 
 // Placemark Structure representing a kml placemark
@@ -65,87 +81,275 @@ type Placemark struct {
 	}
 	Description string `xml:"description"`
 	Point       struct {
-		Coordinates string `xml:"coordinates"`
+		AltitudeMode string `xml:"altitudeMode,omitempty"`
+		Coordinates  string `xml:"coordinates"`
+	}
+	StyleURL     string        `xml:"styleUrl"`
+	ExtendedData *ExtendedData `xml:"ExtendedData,omitempty"`
+}
+
+// TrackPlacemark Structure representing a kml placemark with a segment of the track
+type TrackPlacemark struct {
+	XMLName  xml.Name `xml:"Placemark"`
+	TimeSpan struct {
+		Begin string `xml:"begin"`
+		End   string `xml:"end"`
+	}
+	StyleURL   string `xml:"styleUrl"`
+	LineString struct {
+		Tessellate   int    `xml:"tessellate,omitempty"`
+		AltitudeMode string `xml:"altitudeMode,omitempty"`
+		Coordinates  string `xml:"coordinates"`
+	}
+}
+
+// ExtendedData Structure representing kml extended data
+type ExtendedData struct {
+	Data []Data `xml:"Data"`
+}
+
+// Data Structure representing a named kml extended data value
+type Data struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// NewExtendedData Create kml extended data from sample attributes
+func NewExtendedData(attrs Attributes) *ExtendedData {
+
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	ed := new(ExtendedData)
+	for _, attr := range attrs {
+		ed.Data = append(ed.Data, Data{Name: attr.Name, Value: FormatAttributeValue(attr.Value)})
+	}
+
+	return ed
+}
+
+// Format sample measurements as lines for a placemark description
+func measurementDescription(measurements []Measurement, mod byte) string {
+
+	desc := ""
+	for _, m := range measurements {
+		desc += "\n" + m.Name + ": " + strconv.FormatFloat(m.Value, mod, -1, 64) + " " + m.Unit +
+			uncertaintyDescription(m.Uncertainty, m.Unit, mod)
+	}
+
+	return desc
+}
+
+// Format an uncertainty for a placemark description
+func uncertaintyDescription(u *Uncertainty, unit string, mod byte) string {
+
+	if u == nil {
+		return ""
+	}
+
+	return " " + u.Format(unit, mod)
+}
+
+// Format sample attributes as lines for a placemark description
+func attributeDescription(attrs Attributes) string {
+
+	desc := ""
+	for _, attr := range attrs {
+		desc += "\n" + attr.Name + ": " + FormatAttributeValue(attr.Value)
 	}
-	StyleURL string `xml:"styleUrl"`
+
+	return desc
 }
 
-// NewSampleWriterKmz Create a new sample writer
-func NewSampleWriterKmz(kmzFile string, useScientific, useLabels bool, minValue, maxValue float64) (SampleWriter, error) {
+func init() {
+
+	RegisterFormat(Format{
+		Name:         "kmz",
+		Description:  "Google Earth placemarks colored by the value range of the file",
+		Extension:    ".kmz",
+		Options:      append([]string{OptionScientific, OptionLabels, OptionChannel, OptionTrack, OptionAltitude, OptionFolders}, ColorScaleOptions...),
+		SanitizeName: true,
+		New:          NewSampleWriterKmz,
+	})
+}
+
+// NewSampleWriterKmz Create a new sample writer writing a kmz archive to w.
+// The name option is used for the kml file inside the archive and in placemark descriptions
+func NewSampleWriterKmz(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	if opts.Track != "" && opts.Track != "both" && opts.Track != "only" {
+		return nil, errors.New("Track mode not supported: " + opts.Track)
+	}
+
+	altitudeMode, err := kmlAltitudeMode(opts.AltitudeMode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validFolders(opts.Folders)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := opts.ColorScale.WithDefaults(kmzColorScale)
+	err = scale.Validate()
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize a sample writer
 	sw := new(SampleWriterKmz)
+	sw.Name = SafeFileName(opts.Name)
+	sw.UseScientific = opts.UseScientific
+	sw.UseLabels = opts.UseLabels
+	sw.Channel = opts.Channel
+	sw.Track = opts.Track
+	sw.AltitudeMode = altitudeMode
+	sw.ColorScale = scale
+	sw.Folders = opts.Folders
+	sw.Source = opts.Source
+	if len(sw.Source) == 0 {
+		sw.Source = sw.Name
+	}
+	sw.w = w
 
-	// Replace local characters from basename. Google Earth doesn't like them
-	base := filepath.Base(kmzFile)
-	newBase := ""
-	for _, r := range base {
-		if !strings.ContainsAny(validChars, string(r)) {
-			newBase += "_"
-		} else {
-			newBase += string(r)
-		}
+	// The placemark colors depend on the min and max values of the whole file,
+	// so the samples are spooled and the kml file is written on Close
+	sw.spool, err = NewSampleSpool(sw.Channel)
+	if err != nil {
+		return nil, err
 	}
-	sw.KmzFile = filepath.Join(filepath.Dir(kmzFile), string(os.PathSeparator), newBase)
 
-	ext := filepath.Ext(sw.KmzFile)
-	sw.KmlFile = strings.TrimSuffix(sw.KmzFile, ext) + ".kml"
-	sw.MinValue = minValue
-	sw.MaxValue = maxValue
-	sw.UseScientific = useScientific
-	sw.UseLabels = useLabels
+	return sw, nil
+}
+
+// Write Spool a sample for the kml file
+func (sw *SampleWriterKmz) Write(s *Sample) error {
+
+	return sw.spool.Write(s)
+}
+
+// Abort Discard the spooled samples without writing the kmz archive
+func (sw *SampleWriterKmz) Abort() error {
+
+	return sw.spool.Close()
+}
+
+// Close Write the kml file from the spooled samples into a kmz archive
+func (sw *SampleWriterKmz) Close() error {
+
+	defer sw.spool.Close()
+
+	sw.MinValue = sw.spool.MinValue
+	sw.MaxValue = sw.spool.MaxValue
+
+	// Quantile binning needs all the values of the selected channel
+	var values []float64
+	if sw.ColorScale.NeedsValues() && sw.ColorScale.Binning == BinningQuantile {
+		var err error
+		values, err = sw.spool.SortedValues()
+		if err != nil {
+			return err
+		}
+	}
 
 	var err error
-	sw.fd, err = os.Create(sw.KmlFile)
+	sw.classes, err = sw.ColorScale.Resolve(sw.MinValue, sw.MaxValue, sw.spool.MinAbove, values)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	zw := zip.NewWriter(sw.w)
+
+	z, err := zw.Create(kmlFileName(sw.Name))
+	if err != nil {
+		return err
 	}
 
-	sw.fw = bufio.NewWriter(sw.fd)
+	sw.fw = bufio.NewWriter(z)
+
+	err = sw.writeStyles()
+	if err != nil {
+		return err
+	}
+
+	if sw.Track != "only" {
+		folders := &kmlFolders{
+			Grouping: sw.Folders,
+			Source:   sw.Source,
+			Channel:  sw.Channel,
+			Unit:     sw.spool.Unit,
+			Mod:      sw.numberFormat(),
+			Classes:  sw.classes,
+			fw:       sw.fw,
+		}
+
+		err = folders.WriteSpool(sw.spool, sw.writePlacemark)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(sw.Track) > 0 {
+		err = sw.writeTrack()
+		if err != nil {
+			return err
+		}
+	}
+
+	sw.fw.WriteString("  </Document>\n</kml>")
+	err = sw.fw.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = addDonut(zw)
+	if err != nil {
+		return err
+	}
+
+	err = addLegend(zw, sw.classes, legendTitle(sw.Channel), sw.spool.Unit, sw.numberFormat())
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// Add styles to the kml file
+func (sw *SampleWriterKmz) writeStyles() error {
 
-	// Add styles to the kml file
 	var s Style
 	sw.fw.WriteString(xml.Header)
 	sw.fw.WriteString("\n<kml>\n  <Document>\n")
 
-	colors := [...]string{"FFF0FF14", "FF78FFF0", "FF14B4FF", "FF1400FF"}
-
-	for i := 0; i < 4; i++ {
+	for i, color := range sw.classes.Colors {
 		s.ID = strconv.Itoa(i)
 		s.IconStyle.Icon.Href = "files/donut.png"
 		s.IconStyle.Scale = "0.5"
-		s.IconStyle.Color = colors[i]
+		s.IconStyle.Color = color
 		s.LabelStyle.Scale = "0.5"
+		if len(sw.Track) > 0 {
+			s.LineStyle = &LineStyle{Color: color, Width: "4"}
+		}
 		b, err := xml.MarshalIndent(s, "    ", "    ")
 		if err != nil {
-			sw.fd.Close()
-			os.Remove(sw.KmlFile)
-			return nil, err
+			return err
 		}
 		sw.fw.WriteString(string(b) + "\n")
 	}
 
-	return sw, nil
+	return writeLegendOverlay(sw.fw)
 }
 
-// Write Write a sample to the kml file
-func (sw *SampleWriterKmz) Write(s *Sample) error {
+// Write a sample as a placemark to the kml file
+func (sw *SampleWriterKmz) writePlacemark(s *Sample) error {
 
 	var p Placemark
-	var styleID int
 
-	// Calculate the style id for this sample
-	sector := (sw.MaxValue - sw.MinValue) / 4.0
-
-	if s.Value <= sw.MinValue+sector {
-		styleID = 0
-	} else if s.Value <= sw.MinValue+sector*2 {
-		styleID = 1
-	} else if s.Value <= sw.MinValue+sector*3 {
-		styleID = 2
-	} else {
-		styleID = 3
-	}
+	// The selected channel decides the color and label of the placemark
+	m, _ := s.Channel(sw.Channel)
 
 	// Set the number format
 	mod := byte('f')
@@ -155,17 +359,19 @@ func (sw *SampleWriterKmz) Write(s *Sample) error {
 
 	// Initialize a placemark structure
 	if sw.UseLabels {
-		p.Name = strconv.FormatFloat(s.Value, mod, -1, 64) + " " + s.Unit
+		p.Name = strconv.FormatFloat(m.Value, mod, -1, 64) + " " + m.Unit
 	}
-	p.StyleURL = "#" + strconv.Itoa(styleID)
-	p.TimeStamp.When = s.Date.Format("2006-01-02T15:04:05")
-	p.Point.Coordinates = strconv.FormatFloat(s.Longitude, 'f', -1, 64) + "," +
-		strconv.FormatFloat(s.Latitude, 'f', -1, 64)
-	p.Description = "Value: " + strconv.FormatFloat(s.Value, mod, -1, 64) + " " + s.Unit +
+	p.StyleURL = "#" + strconv.Itoa(sw.classes.Class(m.Value))
+	p.TimeStamp.When = FormatDate(s.Date)
+	p.Point.AltitudeMode = sw.AltitudeMode
+	p.Point.Coordinates = kmlCoordinates(s, sw.AltitudeMode)
+	p.Description = "Value: " + strconv.FormatFloat(s.Value, mod, -1, 64) + " " + s.Unit + uncertaintyDescription(s.Uncertainty, s.Unit, mod) +
 		"\nLatitude: " + strconv.FormatFloat(s.Latitude, 'f', -1, 64) +
 		"\nLongitude: " + strconv.FormatFloat(s.Longitude, 'f', -1, 64) +
 		"\nAltitude: " + strconv.FormatFloat(s.Altitude, 'f', -1, 64) +
-		"\nTime: " + s.Date.String() + "\nFile: " + filepath.Base(sw.KmzFile)
+		"\nTime: " + FormatDate(s.Date) + "\nFile: " + sw.Name +
+		measurementDescription(s.Measurements, mod) + attributeDescription(s.Attributes)
+	p.ExtendedData = NewExtendedData(s.Attributes)
 
 	// Write placemark structure to the kml file
 	b, err := xml.MarshalIndent(p, "    ", "    ")
@@ -177,67 +383,175 @@ func (sw *SampleWriterKmz) Write(s *Sample) error {
 	return nil
 }
 
-// Close Finish the kml file and zip it to make a kmz file
-func (sw *SampleWriterKmz) Close() error {
+// Get the number format of the writer
+func (sw *SampleWriterKmz) numberFormat() byte {
 
-	sw.fw.WriteString("  </Document>\n</kml>")
-	sw.fw.Flush()
-	sw.fd.Close()
+	if sw.UseScientific {
+		return 'E'
+	}
+
+	return 'f'
+}
+
+// Write the track as line segments, colored by the value class of the sample starting each segment.
+// Consecutive segments of the same class are joined into one placemark
+func (sw *SampleWriterKmz) writeTrack() error {
+
+	sw.fw.WriteString("    <Folder>\n      <name>Track</name>\n")
+
+	var seg *TrackPlacemark
+	var styleID int
+	var coords []string
+	var source string
+
+	err := sw.spool.Each(func(s *Sample) error {
+
+		m, _ := s.Channel(sw.Channel)
+		id := sw.classes.Class(m.Value)
+		coord := kmlCoordinates(s, sw.AltitudeMode)
+
+		// Merged sample files get a track each, instead of a line from the end of one file to the start of the next
+		src := sampleSource(s, sw.Source)
+		if seg != nil && src != source {
+			if len(coords) > 1 {
+				err := sw.writeTrackPlacemark(seg, coords)
+				if err != nil {
+					return err
+				}
+			}
+			seg = nil
+		}
+		source = src
+
+		if seg != nil {
+
+			// The previous segment ends at this sample
+			coords = append(coords, coord)
+			seg.TimeSpan.End = FormatDate(s.Date)
+
+			if id == styleID {
+				return nil
+			}
+
+			err := sw.writeTrackPlacemark(seg, coords)
+			if err != nil {
+				return err
+			}
+		}
+
+		seg = new(TrackPlacemark)
+		seg.StyleURL = "#" + strconv.Itoa(id)
+		seg.TimeSpan.Begin = FormatDate(s.Date)
+		seg.TimeSpan.End = seg.TimeSpan.Begin
+		styleID = id
+		coords = []string{coord}
 
-	err := sw.zipKml()
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	os.Remove(sw.KmlFile)
+	// A track needs at least two samples
+	if seg != nil && len(coords) > 1 {
+		err = sw.writeTrackPlacemark(seg, coords)
+		if err != nil {
+			return err
+		}
+	}
+
+	sw.fw.WriteString("    </Folder>\n")
 
 	return nil
 }
 
-// Zip the kml file
-func (sw *SampleWriterKmz) zipKml() error {
+// Write a segment of the track to the kml file
+func (sw *SampleWriterKmz) writeTrackPlacemark(p *TrackPlacemark, coords []string) error {
 
-	// Create kmz file
-	zfout, err := os.Create(sw.KmzFile)
+	if sw.AltitudeMode == "" {
+		p.LineString.Tessellate = 1
+	}
+	p.LineString.AltitudeMode = sw.AltitudeMode
+	p.LineString.Coordinates = strings.Join(coords, " ")
+
+	b, err := xml.MarshalIndent(p, "      ", "    ")
 	if err != nil {
 		return err
 	}
-	defer zfout.Close()
+	sw.fw.WriteString(string(b) + "\n")
 
-	zw := zip.NewWriter(zfout)
-	defer zw.Close() // FIXME: error checking
+	return nil
+}
 
-	kmlFileBase := path.Base(filepath.ToSlash(sw.KmlFile))
+// Get the title of the legend for a measurement channel
+func legendTitle(channel string) string {
 
-	z, err := zw.Create(kmlFileBase)
-	if err != nil {
-		return err
+	if len(channel) == 0 || channel == PrimaryChannel {
+		return "Value"
 	}
 
-	b, err := ioutil.ReadFile(sw.KmlFile)
-	if err != nil {
-		return err
+	return channel
+}
+
+// Get the kml altitude mode element for an altitude mode option. Clamping to the ground is the kml default
+func kmlAltitudeMode(mode string) (string, error) {
+
+	switch mode {
+	case "", "clampToGround":
+		return "", nil
+	case "relativeToGround", "absolute":
+		return mode, nil
 	}
 
-	_, err = z.Write(b)
-	if err != nil {
-		return err
+	return "", errors.New("Altitude mode not supported: " + mode)
+}
+
+// Get the kml coordinates of a sample. The altitude is left out when clamping to the ground
+func kmlCoordinates(s *Sample, altitudeMode string) string {
+
+	coords := strconv.FormatFloat(s.Longitude, 'f', -1, 64) + "," + strconv.FormatFloat(s.Latitude, 'f', -1, 64)
+	if len(altitudeMode) > 0 {
+		coords += "," + strconv.FormatFloat(s.Altitude, 'f', -1, 64)
 	}
 
-	z, err = zw.Create("files/donut.png")
-	if err != nil {
-		return err
+	return coords
+}
+
+// SafeFileName Replace local characters in the base name of a kmz file. Google Earth doesn't like them
+func SafeFileName(fileName string) string {
+
+	base := filepath.Base(fileName)
+	newBase := ""
+	for _, r := range base {
+		if !strings.ContainsAny(validChars, string(r)) {
+			newBase += "_"
+		} else {
+			newBase += string(r)
+		}
 	}
 
-	b, err = base64.StdEncoding.DecodeString(PngDonut)
+	return filepath.Join(filepath.Dir(fileName), newBase)
+}
+
+// Get the name of the kml file inside a kmz archive
+func kmlFileName(kmzName string) string {
+
+	return strings.TrimSuffix(kmzName, filepath.Ext(kmzName)) + ".kml"
+}
+
+// Add the placemark icon to a kmz archive
+func addDonut(zw *zip.Writer) error {
+
+	z, err := zw.Create("files/donut.png")
 	if err != nil {
 		return err
 	}
 
-	_, err = z.Write(b)
+	b, err := base64.StdEncoding.DecodeString(PngDonut)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = z.Write(b)
+	return err
 }
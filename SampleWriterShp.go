@@ -0,0 +1,582 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Projection of the shapefile coordinates
+const prjWGS84 = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// Size of a point record in the shp file, including the record header
+const shpPointRecordSize = 28
+
+// SampleWriterShp Structure representing a sample writer
+type SampleWriterShp struct {
+	Name       string
+	OutputFile string
+	Zip        bool
+	w          io.Writer
+	spool      *SampleSpool
+	fields     []*dbfField
+}
+
+// Structure representing a file of the shapefile set
+type shpPart struct {
+	ext   string
+	write func(w io.Writer) error
+}
+
+// Extensions of the files of the set written next to the shp file
+var shpSiblings = []string{".shx", ".dbf", ".prj", ".cpg"}
+
+// Structure representing a dbf column
+type dbfField struct {
+	name     string
+	typ      byte
+	width    int
+	decimals int
+	value    func(s *Sample) (interface{}, bool)
+}
+
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "shp",
+		Description: "ESRI Shapefile, the .shx, .dbf, .prj and .cpg files are written next to the .shp file",
+		Extension:   ".shp",
+		New:         NewSampleWriterShp,
+	})
+
+	RegisterFormat(Format{
+		Name:        "shp-zip",
+		Description: "ESRI Shapefile set in a zip archive",
+		Extension:   ".shp.zip",
+		New:         NewSampleWriterShpZip,
+	})
+}
+
+// NewSampleWriterShp Create a new shapefile sample writer writing the .shp file to w.
+// The other files of the set are created next to the OutputFile option
+func NewSampleWriterShp(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	if len(opts.OutputFile) == 0 {
+		return nil, errors.New("The shp format needs an output file, use shp-zip to write to a stream")
+	}
+
+	return newSampleWriterShp(w, opts, false)
+}
+
+// NewSampleWriterShpZip Create a new shapefile sample writer writing a zip archive with the whole set to w
+func NewSampleWriterShpZip(w io.Writer, opts WriterOptions) (SampleWriter, error) {
+
+	return newSampleWriterShp(w, opts, true)
+}
+
+// Create a new shapefile sample writer
+func newSampleWriterShp(w io.Writer, opts WriterOptions, zipped bool) (SampleWriter, error) {
+
+	// Initialize a sample writer
+	sw := new(SampleWriterShp)
+	sw.Name = strings.TrimSuffix(strings.TrimSuffix(opts.Name, ".zip"), ".shp")
+	sw.OutputFile = opts.OutputFile
+	sw.Zip = zipped
+	sw.w = w
+
+	// The file headers hold the record count, extent and column widths,
+	// so the samples are spooled and the files are written on Close
+	var err error
+	sw.spool, err = NewSampleSpool("")
+	if err != nil {
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+// Write Spool a sample for the shapefile
+func (sw *SampleWriterShp) Write(s *Sample) error {
+
+	return sw.spool.Write(s)
+}
+
+// Abort Discard the spooled samples without writing the shapefile set. The files of the set next to
+// the shp file are removed, as the caller removes the unfinished shp file itself
+func (sw *SampleWriterShp) Abort() error {
+
+	sw.removeSiblings()
+
+	return sw.spool.Close()
+}
+
+// Remove the files of the set written next to the shp file
+func (sw *SampleWriterShp) removeSiblings() {
+
+	if sw.Zip {
+		return
+	}
+
+	base := strings.TrimSuffix(sw.OutputFile, filepath.Ext(sw.OutputFile))
+	for _, ext := range shpSiblings {
+		os.Remove(base + ext)
+	}
+}
+
+// Close Write the shapefile set from the spooled samples. If that fails, the files of the set
+// next to the shp file are removed, like the caller removes the unfinished shp file
+func (sw *SampleWriterShp) Close() error {
+
+	defer sw.spool.Close()
+
+	err := sw.scanFields()
+	if err != nil {
+		sw.removeSiblings()
+		return err
+	}
+
+	parts := []shpPart{
+		{".shp", sw.writeShp},
+		{".shx", sw.writeShx},
+		{".dbf", sw.writeDbf},
+		{".prj", func(w io.Writer) error { _, err := io.WriteString(w, prjWGS84); return err }},
+		{".cpg", func(w io.Writer) error { _, err := io.WriteString(w, "UTF-8"); return err }},
+	}
+
+	if sw.Zip {
+
+		zw := zip.NewWriter(sw.w)
+		for _, part := range parts {
+			z, err := zw.Create(sw.Name + part.ext)
+			if err != nil {
+				return err
+			}
+
+			err = part.write(z)
+			if err != nil {
+				return err
+			}
+		}
+
+		return zw.Close()
+	}
+
+	err = sw.writeParts(parts)
+	if err != nil {
+		sw.removeSiblings()
+		return err
+	}
+
+	return nil
+}
+
+// Write the shp file to the writer given to the constructor and the other files of the set next to it
+func (sw *SampleWriterShp) writeParts(parts []shpPart) error {
+
+	base := strings.TrimSuffix(sw.OutputFile, filepath.Ext(sw.OutputFile))
+
+	for _, part := range parts {
+
+		if part.ext == ".shp" {
+			err := part.write(sw.w)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fd, err := os.Create(base + part.ext)
+		if err != nil {
+			return err
+		}
+
+		err = part.write(fd)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+
+		err = fd.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find the dbf columns and their widths from the spooled samples
+func (sw *SampleWriterShp) scanFields() error {
+
+	sw.fields = []*dbfField{
+		{name: "DATE", typ: 'C', value: func(s *Sample) (interface{}, bool) { return FormatDate(s.Date), true }},
+		{name: "ALTITUDE", typ: 'N', value: func(s *Sample) (interface{}, bool) { return s.Altitude, true }},
+		{name: "VALUE", typ: 'N', value: func(s *Sample) (interface{}, bool) { return s.Value, true }},
+		{name: "UNIT", typ: 'C', value: func(s *Sample) (interface{}, bool) { return s.Unit, true }},
+	}
+
+	var uncertainty bool
+	var channels, attributes []string
+	attrTypes := make(map[string]byte)
+
+	err := sw.spool.Each(func(s *Sample) error {
+
+		if s.Uncertainty != nil {
+			uncertainty = true
+		}
+
+		for _, m := range s.Measurements {
+			if !containsString(channels, m.Name) {
+				channels = append(channels, m.Name)
+			}
+		}
+
+		for _, attr := range s.Attributes {
+			if !containsString(attributes, attr.Name) {
+				attributes = append(attributes, attr.Name)
+			}
+			attrTypes[attr.Name] = mergeDbfType(attrTypes[attr.Name], attr.Value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if uncertainty {
+		sw.fields = append(sw.fields,
+			&dbfField{name: "UNCERT", typ: 'N', value: func(s *Sample) (interface{}, bool) {
+				if s.Uncertainty == nil {
+					return nil, false
+				}
+				return s.Uncertainty.Absolute(s.Value), true
+			}},
+			&dbfField{name: "COVERAGE", typ: 'N', value: func(s *Sample) (interface{}, bool) {
+				if s.Uncertainty == nil {
+					return nil, false
+				}
+				return s.Uncertainty.Coverage, true
+			}})
+	}
+
+	for _, name := range channels {
+		channel := name
+		sw.fields = append(sw.fields,
+			&dbfField{name: channel, typ: 'N', value: func(s *Sample) (interface{}, bool) {
+				m, ok := s.Channel(channel)
+				return m.Value, ok
+			}},
+			&dbfField{name: channel + "_U", typ: 'C', value: func(s *Sample) (interface{}, bool) {
+				m, ok := s.Channel(channel)
+				return m.Unit, ok
+			}})
+	}
+
+	for _, name := range attributes {
+		attribute := name
+		sw.fields = append(sw.fields, &dbfField{name: attribute, typ: attrTypes[attribute], value: func(s *Sample) (interface{}, bool) {
+			v, ok := s.Attributes.Get(attribute)
+			return v, ok && v != nil
+		}})
+	}
+
+	// Column names are limited to 10 characters and must be unique
+	used := make(map[string]bool)
+	for _, f := range sw.fields {
+		f.name = uniqueDbfName(f.name, used)
+		f.width = 1
+		if f.typ == 'N' {
+			f.width, f.decimals = 24, 15
+		}
+	}
+
+	// Character columns are as wide as their longest value
+	return sw.spool.Each(func(s *Sample) error {
+
+		for _, f := range sw.fields {
+			if f.typ != 'C' {
+				continue
+			}
+
+			v, ok := f.value(s)
+			if !ok {
+				continue
+			}
+
+			n := len(FormatAttributeValue(v))
+			if n > f.width {
+				f.width = n
+			}
+			if f.width > 254 {
+				f.width = 254
+			}
+		}
+
+		return nil
+	})
+}
+
+// Write the shp file
+func (sw *SampleWriterShp) writeShp(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+
+	xmin, ymin, xmax, ymax, err := sw.extent()
+	if err != nil {
+		return err
+	}
+
+	length := 100 + shpPointRecordSize*sw.spool.Count
+	writeShpHeader(bw, length, xmin, ymin, xmax, ymax)
+
+	recNum := 0
+	err = sw.spool.Each(func(s *Sample) error {
+
+		recNum++
+		binary.Write(bw, binary.BigEndian, int32(recNum))
+		binary.Write(bw, binary.BigEndian, int32(10))
+		binary.Write(bw, binary.LittleEndian, int32(1))
+		binary.Write(bw, binary.LittleEndian, s.Longitude)
+		return binary.Write(bw, binary.LittleEndian, s.Latitude)
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Write the shx file
+func (sw *SampleWriterShp) writeShx(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+
+	xmin, ymin, xmax, ymax, err := sw.extent()
+	if err != nil {
+		return err
+	}
+
+	length := 100 + 8*sw.spool.Count
+	writeShpHeader(bw, length, xmin, ymin, xmax, ymax)
+
+	for i := 0; i < sw.spool.Count; i++ {
+		binary.Write(bw, binary.BigEndian, int32((100+shpPointRecordSize*i)/2))
+		binary.Write(bw, binary.BigEndian, int32(10))
+	}
+
+	return bw.Flush()
+}
+
+// Write the dbf file
+func (sw *SampleWriterShp) writeDbf(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+
+	recLen := 1
+	for _, f := range sw.fields {
+		recLen += f.width
+	}
+
+	// File header
+	now := time.Now()
+	header := make([]byte, 32)
+	header[0] = 0x03
+	header[1] = byte(now.Year() - 1900)
+	header[2] = byte(now.Month())
+	header[3] = byte(now.Day())
+	binary.LittleEndian.PutUint32(header[4:], uint32(sw.spool.Count))
+	binary.LittleEndian.PutUint16(header[8:], uint16(32+32*len(sw.fields)+1))
+	binary.LittleEndian.PutUint16(header[10:], uint16(recLen))
+	bw.Write(header)
+
+	// Field descriptors
+	for _, f := range sw.fields {
+		desc := make([]byte, 32)
+		copy(desc[0:10], f.name)
+		desc[11] = f.typ
+		desc[16] = byte(f.width)
+		desc[17] = byte(f.decimals)
+		bw.Write(desc)
+	}
+	bw.WriteByte(0x0D)
+
+	// Records
+	err := sw.spool.Each(func(s *Sample) error {
+
+		bw.WriteByte(' ')
+		for _, f := range sw.fields {
+			v, ok := f.value(s)
+			bw.WriteString(formatDbfValue(f, v, ok))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bw.WriteByte(0x1A)
+
+	return bw.Flush()
+}
+
+// Get the bounding box of the spooled samples
+func (sw *SampleWriterShp) extent() (xmin, ymin, xmax, ymax float64, err error) {
+
+	first := true
+	err = sw.spool.Each(func(s *Sample) error {
+
+		if first {
+			first = false
+			xmin, xmax = s.Longitude, s.Longitude
+			ymin, ymax = s.Latitude, s.Latitude
+			return nil
+		}
+
+		xmin = math.Min(xmin, s.Longitude)
+		xmax = math.Max(xmax, s.Longitude)
+		ymin = math.Min(ymin, s.Latitude)
+		ymax = math.Max(ymax, s.Latitude)
+
+		return nil
+	})
+
+	return
+}
+
+// Write the header shared by the shp and shx files. The length is given in bytes
+func writeShpHeader(w io.Writer, length int, xmin, ymin, xmax, ymax float64) {
+
+	binary.Write(w, binary.BigEndian, int32(9994))
+	binary.Write(w, binary.BigEndian, [5]int32{})
+	binary.Write(w, binary.BigEndian, int32(length/2))
+	binary.Write(w, binary.LittleEndian, int32(1000))
+	binary.Write(w, binary.LittleEndian, int32(1))
+	binary.Write(w, binary.LittleEndian, [8]float64{xmin, ymin, xmax, ymax, 0, 0, 0, 0})
+}
+
+// Format a value as a fixed width dbf field
+func formatDbfValue(f *dbfField, v interface{}, ok bool) string {
+
+	if !ok {
+		return strings.Repeat(" ", f.width)
+	}
+
+	switch f.typ {
+	case 'N':
+		fv, _ := v.(float64)
+		for dec := f.decimals; dec >= 0; dec-- {
+			s := strconv.FormatFloat(fv, 'f', dec, 64)
+			if len(s) <= f.width {
+				return strings.Repeat(" ", f.width-len(s)) + s
+			}
+		}
+		return strings.Repeat("*", f.width)
+	case 'L':
+		if b, _ := v.(bool); b {
+			return "T"
+		}
+		return "F"
+	}
+
+	// Widths are in bytes, so long values are cut on a rune boundary to keep the text valid UTF-8
+	s := FormatAttributeValue(v)
+	if len(s) > f.width {
+		n := f.width
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n]
+	}
+
+	return s + strings.Repeat(" ", f.width-len(s))
+}
+
+// Find the dbf type able to hold all values of an attribute
+func mergeDbfType(typ byte, v interface{}) byte {
+
+	var t byte
+	switch v.(type) {
+	case nil:
+		return typ
+	case float64:
+		t = 'N'
+	case bool:
+		t = 'L'
+	default:
+		t = 'C'
+	}
+
+	if typ == 0 || typ == t {
+		return t
+	}
+
+	return 'C'
+}
+
+// Make a valid and unique dbf column name
+func uniqueDbfName(name string, used map[string]bool) string {
+
+	clean := ""
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			clean += string(r)
+		} else {
+			clean += "_"
+		}
+	}
+
+	if len(clean) > 10 {
+		clean = clean[:10]
+	}
+
+	unique := clean
+	for i := 1; used[unique]; i++ {
+		suffix := strconv.Itoa(i)
+		if len(clean)+len(suffix) > 10 {
+			unique = clean[:10-len(suffix)] + suffix
+		} else {
+			unique = clean + suffix
+		}
+	}
+	used[unique] = true
+
+	return unique
+}
+
+// Check if a string is in a list
+func containsString(list []string, s string) bool {
+
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
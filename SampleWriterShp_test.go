@@ -0,0 +1,346 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// Samples written by the shapefile tests
+func shpTestSamples() []*Sample {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	return []*Sample{
+		{Date: date, Latitude: 59.9, Longitude: 10.7, Altitude: 100, Value: 0.1, Unit: "uSv/h",
+			Attributes: Attributes{{Name: "detector id", Value: "D1"}}},
+		{Date: date.Add(10 * time.Second), Latitude: 59.8, Longitude: 10.9, Altitude: 101, Value: 0.5, Unit: "uSv/h",
+			Attributes: Attributes{{Name: "detector id", Value: "Detector 2"}}},
+		{Date: date.Add(20 * time.Second), Latitude: 60.1, Longitude: 10.6, Altitude: 102, Value: 2.5, Unit: "uSv/h"},
+	}
+}
+
+// Write samples with the shp-zip format and return the files of the archive
+func writeShpZip(t *testing.T, samples []*Sample) map[string][]byte {
+
+	var buf bytes.Buffer
+
+	sw, err := NewSampleWriterShpZip(&buf, WriterOptions{Name: "survey.shp.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range samples {
+		err = sw.Write(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = sw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files[f.Name], err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"survey.shp", "survey.shx", "survey.dbf", "survey.prj", "survey.cpg"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("%s is missing from the archive", name)
+		}
+	}
+
+	return files
+}
+
+// Check the header shared by the shp and shx files
+func checkShpHeader(t *testing.T, name string, b []byte, samples []*Sample) {
+
+	if len(b) < 100 {
+		t.Fatalf("%s: header is %d bytes", name, len(b))
+	}
+
+	if code := binary.BigEndian.Uint32(b[0:]); code != 9994 {
+		t.Errorf("%s: file code is %d, expected 9994", name, code)
+	}
+
+	if words := int(binary.BigEndian.Uint32(b[24:])); words*2 != len(b) {
+		t.Errorf("%s: file length is %d words, expected %d", name, words, len(b)/2)
+	}
+
+	if version := binary.LittleEndian.Uint32(b[28:]); version != 1000 {
+		t.Errorf("%s: version is %d, expected 1000", name, version)
+	}
+
+	if typ := binary.LittleEndian.Uint32(b[32:]); typ != 1 {
+		t.Errorf("%s: shape type is %d, expected 1", name, typ)
+	}
+
+	xmin, ymin := math.Inf(1), math.Inf(1)
+	xmax, ymax := math.Inf(-1), math.Inf(-1)
+	for _, s := range samples {
+		xmin, xmax = math.Min(xmin, s.Longitude), math.Max(xmax, s.Longitude)
+		ymin, ymax = math.Min(ymin, s.Latitude), math.Max(ymax, s.Latitude)
+	}
+
+	for i, want := range []float64{xmin, ymin, xmax, ymax} {
+		got := math.Float64frombits(binary.LittleEndian.Uint64(b[36+8*i:]))
+		if got != want {
+			t.Errorf("%s: bounding box value %d is %v, expected %v", name, i, got, want)
+		}
+	}
+}
+
+func TestShpRecords(t *testing.T) {
+
+	samples := shpTestSamples()
+	files := writeShpZip(t, samples)
+
+	shp := files["survey.shp"]
+	shx := files["survey.shx"]
+
+	if len(shp) != 100+shpPointRecordSize*len(samples) {
+		t.Fatalf("shp file is %d bytes, expected %d", len(shp), 100+shpPointRecordSize*len(samples))
+	}
+
+	if len(shx) != 100+8*len(samples) {
+		t.Fatalf("shx file is %d bytes, expected %d", len(shx), 100+8*len(samples))
+	}
+
+	checkShpHeader(t, "shp", shp, samples)
+	checkShpHeader(t, "shx", shx, samples)
+
+	for i, s := range samples {
+
+		// The index gives the offset and content length of each record in words
+		offset := int(binary.BigEndian.Uint32(shx[100+8*i:])) * 2
+		length := int(binary.BigEndian.Uint32(shx[104+8*i:])) * 2
+
+		if offset != 100+shpPointRecordSize*i {
+			t.Errorf("record %d: offset is %d, expected %d", i+1, offset, 100+shpPointRecordSize*i)
+			continue
+		}
+
+		rec := shp[offset:]
+		if num := int(binary.BigEndian.Uint32(rec[0:])); num != i+1 {
+			t.Errorf("record %d: record number is %d", i+1, num)
+		}
+
+		if n := int(binary.BigEndian.Uint32(rec[4:])) * 2; n != length || n != shpPointRecordSize-8 {
+			t.Errorf("record %d: content length is %d bytes, index has %d, expected %d", i+1, n, length, shpPointRecordSize-8)
+		}
+
+		if typ := binary.LittleEndian.Uint32(rec[8:]); typ != 1 {
+			t.Errorf("record %d: shape type is %d, expected 1", i+1, typ)
+		}
+
+		x := math.Float64frombits(binary.LittleEndian.Uint64(rec[12:]))
+		y := math.Float64frombits(binary.LittleEndian.Uint64(rec[20:]))
+		if x != s.Longitude || y != s.Latitude {
+			t.Errorf("record %d: point is %v %v, expected %v %v", i+1, x, y, s.Longitude, s.Latitude)
+		}
+	}
+}
+
+func TestDbfTable(t *testing.T) {
+
+	samples := shpTestSamples()
+	dbf := writeShpZip(t, samples)["survey.dbf"]
+
+	if len(dbf) < 32 || dbf[0] != 0x03 {
+		t.Fatal("dbf file has no dBase III header")
+	}
+
+	if count := int(binary.LittleEndian.Uint32(dbf[4:])); count != len(samples) {
+		t.Errorf("record count is %d, expected %d", count, len(samples))
+	}
+
+	headerLen := int(binary.LittleEndian.Uint16(dbf[8:]))
+	recLen := int(binary.LittleEndian.Uint16(dbf[10:]))
+
+	type field struct {
+		name  string
+		typ   byte
+		width int
+	}
+
+	want := []field{
+		{"DATE", 'C', len(FormatDate(samples[0].Date))},
+		{"ALTITUDE", 'N', 24},
+		{"VALUE", 'N', 24},
+		{"UNIT", 'C', len("uSv/h")},
+		{"DETECTOR_I", 'C', len("Detector 2")},
+	}
+
+	if headerLen != 32+32*len(want)+1 {
+		t.Fatalf("header length is %d, expected %d", headerLen, 32+32*len(want)+1)
+	}
+
+	if dbf[headerLen-1] != 0x0D {
+		t.Errorf("field descriptors are not terminated")
+	}
+
+	width := 1
+	var fields []field
+	for i := range want {
+		desc := dbf[32+32*i : 64+32*i]
+		f := field{name: strings.TrimRight(string(desc[0:11]), "\x00"), typ: desc[11], width: int(desc[16])}
+		if f != want[i] {
+			t.Errorf("field %d is %v, expected %v", i+1, f, want[i])
+		}
+		fields = append(fields, f)
+		width += f.width
+	}
+
+	if recLen != width {
+		t.Errorf("record length is %d, expected %d", recLen, width)
+	}
+
+	if len(dbf) != headerLen+recLen*len(samples)+1 || dbf[len(dbf)-1] != 0x1A {
+		t.Fatalf("dbf file is %d bytes, expected %d ending with 0x1A", len(dbf), headerLen+recLen*len(samples)+1)
+	}
+
+	for i, s := range samples {
+		rec := dbf[headerLen+recLen*i : headerLen+recLen*(i+1)]
+		if rec[0] != ' ' {
+			t.Errorf("record %d is marked as deleted", i+1)
+		}
+
+		values := make(map[string]string)
+		pos := 1
+		for _, f := range fields {
+			values[f.name] = strings.TrimSpace(string(rec[pos : pos+f.width]))
+			pos += f.width
+		}
+
+		if values["DATE"] != FormatDate(s.Date) {
+			t.Errorf("record %d: DATE is %q, expected %q", i+1, values["DATE"], FormatDate(s.Date))
+		}
+
+		if v, err := strconv.ParseFloat(values["VALUE"], 64); err != nil || v != s.Value {
+			t.Errorf("record %d: VALUE is %q, expected %v", i+1, values["VALUE"], s.Value)
+		}
+
+		if values["UNIT"] != s.Unit {
+			t.Errorf("record %d: UNIT is %q, expected %q", i+1, values["UNIT"], s.Unit)
+		}
+
+		detector := ""
+		if v, ok := s.Attributes.Get("detector id"); ok {
+			detector = FormatAttributeValue(v)
+		}
+
+		if values["DETECTOR_I"] != detector {
+			t.Errorf("record %d: DETECTOR_I is %q, expected %q", i+1, values["DETECTOR_I"], detector)
+		}
+	}
+}
+
+func TestFormatDbfValue(t *testing.T) {
+
+	tests := []struct {
+		field dbfField
+		value interface{}
+		ok    bool
+		want  string
+	}{
+		{dbfField{typ: 'C', width: 5}, "ab", true, "ab   "},
+		{dbfField{typ: 'C', width: 5}, "abcdefg", true, "abcde"},
+		{dbfField{typ: 'C', width: 5}, "abcdé", true, "abcd "},
+		{dbfField{typ: 'C', width: 5}, "ææææ", true, "ææ "},
+		{dbfField{typ: 'C', width: 3}, nil, false, "   "},
+		{dbfField{typ: 'N', width: 8, decimals: 3}, 2.5, true, "   2.500"},
+		{dbfField{typ: 'N', width: 4, decimals: 3}, 12.5, true, "12.5"},
+		{dbfField{typ: 'N', width: 2, decimals: 0}, 1234.0, true, "**"},
+		{dbfField{typ: 'L', width: 1}, true, true, "T"},
+	}
+
+	for _, test := range tests {
+		got := formatDbfValue(&test.field, test.value, test.ok)
+		if got != test.want {
+			t.Errorf("formatDbfValue(%c%d, %v) is %q, expected %q", test.field.typ, test.field.width, test.value, got, test.want)
+		}
+
+		if len(got) != test.field.width || !utf8.ValidString(got) {
+			t.Errorf("formatDbfValue(%c%d, %v) is %d bytes of valid UTF-8 %v, expected %d", test.field.typ, test.field.width, test.value, len(got), utf8.ValidString(got), test.field.width)
+		}
+	}
+}
+
+func TestShpAbortRemovesSiblings(t *testing.T) {
+
+	outputFile := filepath.Join(t.TempDir(), "survey.shp")
+
+	// Files of the set left by an earlier conversion
+	for _, ext := range shpSiblings {
+		err := ioutil.WriteFile(strings.TrimSuffix(outputFile, ".shp")+ext, []byte("old"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sw, err := NewSampleWriterShp(ioutil.Discard, WriterOptions{Name: "survey.shp", OutputFile: outputFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range shpTestSamples() {
+		err = sw.Write(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = AbortSampleWriter(sw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ext := range shpSiblings {
+		_, err := os.Stat(strings.TrimSuffix(outputFile, ".shp") + ext)
+		if !os.IsNotExist(err) {
+			t.Errorf("%s file is left after abort", ext)
+		}
+	}
+}
@@ -13,35 +13,36 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 // Copyright: (c) 2015 Norwegian Radiation Protection Authority
 // Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
 
-package main
+package sampleconverter
 
 import (
 	"bufio"
 	"encoding/xml"
-	"os"
+	"io"
 )
 
 // SampleWriterXML Structure representing a sample writer
 type SampleWriterXML struct {
-	xmlFile string
-	fd      *os.File
-	fw      *bufio.Writer
+	fw *bufio.Writer
 }
 
-// NewSampleWriterXML Create a new sample writer
-func NewSampleWriterXML(xmlFile string) (SampleWriter, error) {
+func init() {
+
+	RegisterFormat(Format{
+		Name:        "xml",
+		Description: "XML document of samples",
+		Extension:   ".xml",
+		New:         NewSampleWriterXML,
+	})
+}
+
+// NewSampleWriterXML Create a new sample writer writing to w
+func NewSampleWriterXML(w io.Writer, opts WriterOptions) (SampleWriter, error) {
 
 	// Initialize a sample writer
 	sw := new(SampleWriterXML)
-	sw.xmlFile = xmlFile
-
-	var err error
-	sw.fd, err = os.Create(sw.xmlFile)
-	if err != nil {
-		return nil, err
-	}
 
-	sw.fw = bufio.NewWriter(sw.fd)
+	sw.fw = bufio.NewWriter(w)
 	sw.fw.WriteString(xml.Header)
 	sw.fw.WriteString("\n<samples>\n")
 
@@ -64,8 +65,5 @@ func (sw *SampleWriterXML) Write(s *Sample) error {
 func (sw *SampleWriterXML) Close() error {
 
 	sw.fw.WriteString("</samples>")
-	sw.fw.Flush()
-	sw.fd.Close()
-
-	return nil
+	return sw.fw.Flush()
 }
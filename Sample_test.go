@@ -0,0 +1,55 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package sampleconverter
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestSampleXML(t *testing.T) {
+
+	date := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sample *Sample
+		want   string
+	}{
+		// Plain samples are written as before samples had channels and attributes
+		{&Sample{Date: date, Latitude: 59.9, Longitude: 10.7, Altitude: 100, Value: 0.1, Unit: "uSv/h"},
+			`<sample><date>2015-03-01T10:00:00Z</date><latitude>59.9</latitude><longitude>10.7</longitude>` +
+				`<altitude>100</altitude><value>0.1</value><unit>uSv/h</unit></sample>`},
+		{&Sample{Date: date, Value: 0.1, Unit: "uSv/h",
+			Measurements: Measurements{{Name: "cps", Value: 12, Unit: "1/s"}},
+			Attributes:   Attributes{{Name: "detector", Value: "D1"}}},
+			`<sample><date>2015-03-01T10:00:00Z</date><latitude>0</latitude><longitude>0</longitude>` +
+				`<altitude>0</altitude><value>0.1</value><unit>uSv/h</unit>` +
+				`<measurements><measurement name="cps"><value>12</value><unit>1/s</unit></measurement></measurements>` +
+				`<attributes><attribute name="detector">D1</attribute></attributes></sample>`},
+	}
+
+	for _, test := range tests {
+		b, err := xml.Marshal(test.sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != test.want {
+			t.Errorf("sample is written as\n%s\nexpected\n%s", b, test.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"github.com/bytting/SampleConverter"
+)
+
+// RejectsFile Structure representing a csv file listing the sample lines rejected by plugins.
+// The file is created when the first rejected line is written
+type RejectsFile struct {
+	FileName string
+	Count    int
+	fd       *os.File
+	cw       *csv.Writer
+}
+
+// NewRejectsFile Create a new rejects file structure
+func NewRejectsFile(fileName string) *RejectsFile {
+
+	rf := new(RejectsFile)
+	rf.FileName = fileName
+
+	return rf
+}
+
+// Write Write the rejected lines of a sample file
+func (rf *RejectsFile) Write(sampleFile string, rejects []*sampleconverter.LineError) error {
+
+	if len(rejects) == 0 {
+		return nil
+	}
+
+	if rf.fd == nil {
+
+		var err error
+		rf.fd, err = os.Create(rf.FileName)
+		if err != nil {
+			return err
+		}
+
+		rf.cw = csv.NewWriter(rf.fd)
+		rf.cw.Write([]string{"File", "Line", "Text", "Reason"})
+	}
+
+	for _, e := range rejects {
+		rf.cw.Write([]string{sampleFile, strconv.Itoa(e.Line), e.Text, e.Err.Error()})
+		rf.Count++
+	}
+
+	rf.cw.Flush()
+	return rf.cw.Error()
+}
+
+// Close Close the rejects file, if it is open
+func (rf *RejectsFile) Close() error {
+
+	if rf.fd == nil {
+		return nil
+	}
+
+	err := rf.fd.Close()
+	rf.fd = nil
+
+	return err
+}
@@ -0,0 +1,945 @@
+/*
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+// Copyright: (c) 2015 Norwegian Radiation Protection Authority
+// Contributors: Dag Robøle (dag D0T robole AT gmail D0T com)
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	// Time zone names used by plugins must work on systems without a time zone database, like Windows
+	_ "time/tzdata"
+
+	"github.com/bytting/SampleConverter"
+)
+
+var progName string
+var version = "0.7"
+
+// Exit status when sample lines were rejected by -continue-on-error
+const exitRejected = 3
+
+// Rejected sample lines, and the number of lines rejected so far by all conversions
+var (
+	rejects     *RejectsFile
+	rejectCount int64
+)
+
+// Color scale given by the color scale flags, nil if none are given
+var colorScale *sampleconverter.ColorScale
+
+// Start of the conversions, the {date} of all output file names
+var startTime = time.Now()
+
+// Output files chosen by the conversions so far
+var (
+	outputFiles = make(map[string]bool)
+	outputMutex sync.Mutex
+)
+
+// Flag variables
+var (
+	usePlugin           string
+	useFormat           string
+	listPlugins         bool
+	listFormats         bool
+	setPluginDirectory  string
+	showPluginDirectory bool
+	showVersion         bool
+	useLabels           bool
+	useScientific       bool
+	useChannel          string
+	useWaypoints        bool
+	useDatabase         string
+	useTable            string
+	useTrack            string
+	useAltitudeMode     string
+	useColorScale       string
+	usePalette          string
+	useClasses          int
+	useBinning          string
+	useBreaks           string
+	useFolders          string
+	mergeOutput         string
+	outputDir           string
+	outputTemplate      string
+	outputExists        string
+	jobs                int
+	continueOnError     bool
+	rejectsFileName     string
+	maxErrors           int
+	pluginTimeout       time.Duration
+	pluginFileTimeout   time.Duration
+	testPlugin          string
+	showHowto           bool
+)
+
+// Settings structure
+type Settings struct {
+	PluginDirectory string `json:"PluginDirectory"`
+}
+
+func init() {
+
+	// Save program name
+	progName = filepath.Base(os.Args[0])
+
+	// Load flags
+	flag.StringVar(&usePlugin, "use-plugin", "", "Convert one or more sample files using the given plugin")
+	flag.StringVar(&useFormat, "use-format", "kmz", "Use the given output format ("+strings.Join(sampleconverter.FormatNames(), ", ")+")")
+	flag.BoolVar(&listPlugins, "list-plugins", false, "List all available plugins")
+	flag.BoolVar(&listFormats, "list-formats", false, "List all available formats")
+	flag.StringVar(&setPluginDirectory, "set-plugin-directory", "", "Set the directory where "+progName+" looks for plugins")
+	flag.BoolVar(&showPluginDirectory, "show-plugin-directory", false, "Show the directory where "+progName+" looks for plugins")
+	flag.BoolVar(&showVersion, "version", false, "Show "+progName+" version")
+	flag.BoolVar(&useLabels, sampleconverter.OptionLabels, false, "Use labels for markers"+formatsSupporting(sampleconverter.OptionLabels))
+	flag.BoolVar(&useScientific, sampleconverter.OptionScientific, false, "Use scientific notation for decimal values"+formatsSupporting(sampleconverter.OptionScientific))
+	flag.StringVar(&useChannel, sampleconverter.OptionChannel, "", "Use the given measurement channel for marker colors and labels"+formatsSupporting(sampleconverter.OptionChannel))
+	flag.BoolVar(&useWaypoints, sampleconverter.OptionWaypoints, false, "Write waypoints instead of a track"+formatsSupporting(sampleconverter.OptionWaypoints))
+	flag.StringVar(&useDatabase, sampleconverter.OptionDatabase, "", "Write all sample files to the given database instead of one database per sample file"+formatsSupporting(sampleconverter.OptionDatabase))
+	flag.StringVar(&useTable, sampleconverter.OptionTable, "", "Write all sample files to one combined table with the given name"+formatsSupporting(sampleconverter.OptionTable))
+	flag.StringVar(&useTrack, sampleconverter.OptionTrack, "", "Write the track as line segments colored like the markers, \"both\" with the markers or \"only\""+formatsSupporting(sampleconverter.OptionTrack))
+	flag.StringVar(&useAltitudeMode, sampleconverter.OptionAltitude, "clampToGround", "Use the given altitude mode, \"clampToGround\", \"relativeToGround\" or \"absolute\""+formatsSupporting(sampleconverter.OptionAltitude))
+	flag.StringVar(&useColorScale, sampleconverter.OptionColorScale, "", "Load the marker color scale from the given json file"+formatsSupporting(sampleconverter.OptionColorScale))
+	flag.StringVar(&usePalette, sampleconverter.OptionPalette, "", "Use the given color palette ("+strings.Join(paletteNames(), ", ")+")"+formatsSupporting(sampleconverter.OptionPalette))
+	flag.IntVar(&useClasses, sampleconverter.OptionClasses, 0, "Use the given number of color classes"+formatsSupporting(sampleconverter.OptionClasses))
+	flag.StringVar(&useBinning, sampleconverter.OptionBinning, "", "Compute color classes by the given binning method (linear, log, quantile)"+formatsSupporting(sampleconverter.OptionBinning))
+	flag.StringVar(&useBreaks, sampleconverter.OptionBreaks, "", "Use the given comma separated class breaks, e.g. 1,5,10,20"+formatsSupporting(sampleconverter.OptionBreaks))
+	flag.StringVar(&useFolders, sampleconverter.OptionFolders, "", "Group markers in folders by \"file\", \"day\", \"hour\" or \"class\""+formatsSupporting(sampleconverter.OptionFolders))
+	flag.StringVar(&mergeOutput, "merge-output", "", "Merge all sample files into the given output file, \"-\" for stdout. Each sample gets a source attribute with its sample file")
+	flag.StringVar(&outputDir, "output-dir", "", "Write output files to the given directory instead of next to the sample files")
+	flag.StringVar(&outputTemplate, "output-template", sampleconverter.DefaultOutputTemplate, "Name output files by the given template, using {name}, {base}, {plugin}, {date}, {format} and {ext}")
+	flag.StringVar(&outputExists, "output-exists", "overwrite", "What to do when an output file exists, \"overwrite\", \"skip\" or \"suffix\" to add a number to the name")
+	flag.IntVar(&jobs, "jobs", 1, "Convert the given number of sample files at the same time")
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "Skip sample lines the plugin fails to parse and list them in the rejects file")
+	flag.StringVar(&rejectsFileName, "rejects-file", "rejects.csv", "Write the sample lines skipped by -continue-on-error to the given csv file")
+	flag.IntVar(&maxErrors, "max-errors", 0, "Stop converting when more than the given number of lines are skipped by -continue-on-error. Zero means no limit")
+	flag.DurationVar(&pluginTimeout, "plugin-timeout", 0, "Stop a plugin spending more than the given time on a sample line, e.g. 5s. Zero means no limit")
+	flag.DurationVar(&pluginFileTimeout, "plugin-file-timeout", 0, "Stop a plugin spending more than the given time on a sample file, e.g. 2m. Zero means no limit")
+	flag.StringVar(&testPlugin, "test-plugin", "", "Check the given plugin against one or more fixture files, comparing the samples of each fixture to the "+sampleconverter.ExpectedSuffix+" file next to it")
+	flag.BoolVar(&showHowto, "show-plugin-howto", false, "Show the plugin howto")
+}
+
+func main() {
+
+	flag.Parse()
+
+	useFormat = strings.ToLower(useFormat)
+
+	// Load settings
+	var settings Settings
+	settingsFile := ConfigFile()
+
+	if !FileExists(settingsFile) {
+
+		settings = Settings{PluginDirectory: PluginDir()}
+		sbytes, _ := json.Marshal(&settings)
+		ioutil.WriteFile(settingsFile, sbytes, 0644)
+
+	} else {
+
+		sbytes, _ := ioutil.ReadFile(settingsFile)
+		json.Unmarshal(sbytes, &settings)
+	}
+
+	// Execute operation based on flags
+	if listPlugins {
+
+		// Print plugin names to stdout
+		plugins, _ := sampleconverter.ListPlugins(settings.PluginDirectory)
+		for _, p := range plugins {
+			fmt.Printf("%s\n", p)
+		}
+
+	} else if listFormats {
+
+		// Print format names, extensions, descriptions and options to stdout
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, f := range sampleconverter.Formats() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Name, f.Extension, f.Description, strings.Join(f.Options, " "))
+		}
+		tw.Flush()
+
+	} else if showPluginDirectory {
+
+		// Print current plugin directory to stdout
+		fmt.Println(settings.PluginDirectory)
+
+	} else if showVersion {
+
+		// Print version
+		fmt.Println(version)
+
+	} else if showHowto {
+
+		// Show plugin howto
+		fmt.Print(sampleconverter.TxtPluginHowto)
+
+	} else if len(setPluginDirectory) > 0 {
+
+		// Set plugin directory
+		dir := filepath.Clean(setPluginDirectory)
+		os.MkdirAll(dir, 0777)
+		settings.PluginDirectory = dir
+		sbytes, _ := json.Marshal(&settings)
+		ioutil.WriteFile(settingsFile, sbytes, 0644)
+
+	} else if len(testPlugin) > 0 {
+
+		// Check a plugin against fixture files
+		if flag.NArg() < 1 {
+			log.Fatalln("ERROR: No fixture files given")
+		}
+
+		pluginFile, err := sampleconverter.FindPlugin(settings.PluginDirectory, testPlugin)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err.Error())
+		}
+
+		fixtureFiles := ArgumentFiles()
+		if len(fixtureFiles) == 0 {
+			log.Fatalln("ERROR: No valid fixture files given")
+		}
+
+		failed := false
+		for _, fixtureFile := range fixtureFiles {
+
+			ok, err := checkPlugin(pluginFile, fixtureFile)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err.Error())
+			}
+			failed = failed || !ok
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+
+	} else if len(usePlugin) > 0 {
+
+		// Convert sample files
+		if flag.NArg() < 1 {
+			log.Fatalln("ERROR: No input files given")
+		}
+
+		pluginFile, err := sampleconverter.FindPlugin(settings.PluginDirectory, usePlugin)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err.Error())
+		}
+
+		format, err := sampleconverter.LookupFormat(useFormat)
+		if err != nil {
+			log.Fatalf("ERROR: Unknown format %s. Use one of: %s", useFormat, strings.Join(sampleconverter.FormatNames(), ", "))
+		}
+
+		// Warn about options the format will ignore
+		flag.Visit(func(f *flag.Flag) {
+			if isWriterOption(f.Name) && !format.Supports(f.Name) {
+				fmt.Fprintf(os.Stderr, "WARNING: Format %s does not support option -%s\n", format.Name, f.Name)
+			}
+		})
+
+		if outputExists != "overwrite" && outputExists != "skip" && outputExists != "suffix" {
+			log.Fatalf("ERROR: Unknown output policy %s. Use one of: overwrite, skip, suffix", outputExists)
+		}
+
+		colorScale, err = loadColorScale()
+		if err != nil {
+			log.Fatalf("ERROR: %s", err.Error())
+		}
+
+		sampleFiles := ArgumentFiles()
+		if len(sampleFiles) == 0 {
+			log.Fatalln("ERROR: No valid input files given")
+		}
+
+		var inputFiles []string
+		for _, sampleFile := range sampleFiles {
+
+			if sampleFile != "-" && !FileExists(sampleFile) {
+				fmt.Fprintf(os.Stderr, "ERROR: Sampling file %s does not exist\n", sampleFile)
+				continue
+			}
+
+			inputFiles = append(inputFiles, sampleFile)
+		}
+
+		rejects = NewRejectsFile(rejectsFileName)
+		defer rejects.Close()
+
+		if len(mergeOutput) > 0 {
+
+			err = mergeSampleFiles(pluginFile, inputFiles, format, mergeOutput)
+			if err != nil {
+				rejects.Close()
+				log.Fatalln(err.Error())
+			}
+
+		} else {
+
+			// Writers adding to the same database can't run at the same time
+			if jobs > 1 && len(useDatabase) > 0 && format.Supports(sampleconverter.OptionDatabase) {
+				fmt.Fprintln(os.Stderr, "WARNING: Converting one file at a time when writing to a single database")
+				jobs = 1
+			}
+
+			err = checkOutputFiles(format, pluginFile, inputFiles)
+			if err != nil {
+				rejects.Close()
+				log.Fatalf("ERROR: %s", err.Error())
+			}
+
+			failed := convertSampleFiles(pluginFile, inputFiles, format)
+			if len(failed) > 0 {
+				fmt.Fprintf(os.Stderr, "ERROR: %d of %d files failed:\n", len(failed), len(inputFiles))
+				for _, msg := range failed {
+					fmt.Fprintf(os.Stderr, "  %s\n", msg)
+				}
+				rejects.Close()
+				os.Exit(1)
+			}
+		}
+
+		if rejects.Count > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: %d lines were rejected, see %s\n", rejects.Count, rejects.FileName)
+			rejects.Close()
+			os.Exit(exitRejected)
+		}
+
+	} else {
+		log.Fatalf("ERROR: Missing arguments.\nUse \"%s -h\" for a description of possible arguments", progName)
+	}
+}
+
+// Check a plugin against a fixture file and print the problems found, line by line
+func checkPlugin(pluginFile, fixtureFile string) (bool, error) {
+
+	fmt.Printf("Checking plugin '%s' with fixture '%s'\n", filepath.Base(pluginFile), filepath.Base(fixtureFile))
+
+	check, err := sampleconverter.CheckPlugin(pluginFile, fixtureFile, readerOptions(fixtureFile))
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range check.Problems {
+
+		// Samples of the begin and end hooks are not made from a line
+		switch p.Line {
+		case 0:
+			fmt.Printf("  begin: %v\n", p.Err)
+		case sampleconverter.EndLine:
+			fmt.Printf("  end: %v\n", p.Err)
+		default:
+			fmt.Printf("  line %d: %v\n", p.Line, p.Err)
+		}
+		if len(p.Text) > 0 {
+			fmt.Printf("    %s\n", p.Text)
+		}
+	}
+
+	if check.Expected > 0 {
+		fmt.Printf("%d samples read, %d expected, %d problems\n", check.Samples, check.Expected, len(check.Problems))
+	} else {
+		fmt.Printf("%d samples read, %d problems. No %s file to compare with\n", check.Samples, len(check.Problems), filepath.Base(sampleconverter.ExpectedFile(fixtureFile)))
+	}
+
+	return len(check.Problems) == 0, nil
+}
+
+// Structure representing the conversion of a sample file by the worker pool
+type conversion struct {
+	sampleFile string
+	progress   bytes.Buffer
+	rejects    []*sampleconverter.LineError
+	err        error
+	done       chan bool
+}
+
+// Convert sample files with a pool of workers, each with its own plugin runtime.
+// Progress messages are printed in the order of the sample files. Returns a message for each failed file
+func convertSampleFiles(pluginFile string, sampleFiles []string, format *sampleconverter.Format) []string {
+
+	// Progress messages must not end up in the output converted to stdout
+	progress := os.Stdout
+	for _, sampleFile := range sampleFiles {
+		if sampleFile == "-" {
+			progress = os.Stderr
+		}
+	}
+
+	var failed []string
+
+	// Write the rejected lines of a finished conversion and note a failure
+	finish := func(c *conversion) {
+
+		if len(c.rejects) > 0 {
+			fmt.Fprintf(progress, "Rejected %d lines in file '%s'\n", len(c.rejects), filepath.Base(c.sampleFile))
+
+			err := rejects.Write(c.sampleFile, c.rejects)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+			}
+		}
+
+		if c.err != nil {
+			failed = append(failed, c.sampleFile+": "+c.err.Error())
+		}
+	}
+
+	conversions := make([]*conversion, len(sampleFiles))
+	for i, sampleFile := range sampleFiles {
+		conversions[i] = &conversion{sampleFile: sampleFile, done: make(chan bool)}
+	}
+
+	// A single worker prints progress as it goes, instead of holding it back until the file is done
+	if jobs <= 1 {
+		for _, c := range conversions {
+			c.err = convertSampleFile(pluginFile, c.sampleFile, format, progress, rejectLines(&c.rejects))
+			finish(c)
+		}
+
+		return failed
+	}
+
+	queue := make(chan *conversion, len(conversions))
+	for _, c := range conversions {
+		queue <- c
+	}
+	close(queue)
+
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for c := range queue {
+				c.err = convertSampleFile(pluginFile, c.sampleFile, format, &c.progress, rejectLines(&c.rejects))
+				close(c.done)
+			}
+		}()
+	}
+
+	for _, c := range conversions {
+
+		<-c.done
+		progress.Write(c.progress.Bytes())
+		finish(c)
+	}
+
+	return failed
+}
+
+// Convert a single sample file, writing progress messages to progress and passing rejected lines to reject.
+// The sample file "-" is read from stdin and converted to stdout
+func convertSampleFile(pluginFile, sampleFile string, format *sampleconverter.Format, progress io.Writer, reject func(e *sampleconverter.LineError) error) error {
+
+	var r io.Reader
+	var source, outputFile string
+
+	if sampleFile == "-" {
+
+		// Progress messages must not end up in the converted output
+		fmt.Fprintf(progress, "Converting stdin with plugin '%s' using format '%s'\n", filepath.Base(pluginFile), format.Name)
+
+		r = os.Stdin
+		source = "stdin"
+
+	} else {
+
+		fmt.Fprintf(progress, "Converting file '%s' with plugin '%s' using format '%s'\n", filepath.Base(sampleFile), filepath.Base(pluginFile), format.Name)
+
+		fin, err := os.Open(sampleFile)
+		if err != nil {
+			return err
+		}
+		defer fin.Close()
+
+		r = fin
+		source = filepath.Base(sampleFile)
+	}
+
+	// Formats writing to a database can add all sample files to the same one
+	if len(useDatabase) > 0 && format.Supports(sampleconverter.OptionDatabase) {
+
+		outputFile = useDatabase
+
+	} else if sampleFile != "-" {
+
+		var err error
+		outputFile, err = outputFileName(format, pluginFile, sampleFile)
+		if err != nil {
+			return err
+		}
+
+		if len(outputFile) == 0 {
+			fmt.Fprintf(progress, "Skipping file '%s', the output file exists\n", filepath.Base(sampleFile))
+			return nil
+		}
+	}
+
+	// The plugin is loaded before the output file is created, so a plugin failing to load leaves it untouched
+	sr, err := sampleconverter.NewSampleReader(pluginFile, r, readerOptions(sampleFile))
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+
+	existed := FileExists(outputFile)
+
+	sw, fout, err := createSampleWriter(format, outputFile, source)
+	if err != nil {
+		return err
+	}
+	if fout != nil {
+		defer fout.Close()
+	}
+
+	_, err = sampleconverter.ConvertTolerant(sr, sw, reject)
+	if err != nil {
+		sampleconverter.AbortSampleWriter(sw)
+		discardOutput(fout, outputFile, existed)
+		return err
+	}
+
+	// Writers that spool their samples do most of their work on close
+	err = sw.Close()
+	if err != nil {
+		discardOutput(fout, outputFile, existed)
+		return err
+	}
+
+	return nil
+}
+
+// Convert all sample files into a single output file. The output file "-" is stdout.
+// The samples get a source attribute with the name of their sample file, and writers
+// computing colors from the value range use the range of all sample files
+func mergeSampleFiles(pluginFile string, sampleFiles []string, format *sampleconverter.Format, outputFile string) error {
+
+	// Progress messages must not end up in the converted output
+	progress := os.Stdout
+	if outputFile == "-" {
+
+		outputFile = ""
+		progress = os.Stderr
+
+	} else {
+
+		var err error
+		outputFile, err = resolveOutputFile(format, outputFile)
+		if err != nil {
+			return err
+		}
+
+		if len(outputFile) == 0 {
+			fmt.Println("Skipping merge, the output file exists")
+			return nil
+		}
+	}
+
+	existed := FileExists(outputFile)
+
+	var sw sampleconverter.SampleWriter
+	var fout *os.File
+
+	// The output file is created once the plugin has loaded for the first sample file,
+	// so a plugin failing to load leaves it untouched
+	writer := func() (sampleconverter.SampleWriter, error) {
+
+		if sw == nil {
+			var err error
+			sw, fout, err = createSampleWriter(format, outputFile, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return sw, nil
+	}
+
+	defer func() {
+		if fout != nil {
+			fout.Close()
+		}
+	}()
+
+	for _, sampleFile := range sampleFiles {
+
+		fmt.Fprintf(progress, "Merging file '%s' with plugin '%s' using format '%s'\n", filepath.Base(sampleFile), filepath.Base(pluginFile), format.Name)
+
+		var lines []*sampleconverter.LineError
+
+		err := mergeSampleFile(pluginFile, sampleFile, writer, rejectLines(&lines))
+
+		if len(lines) > 0 {
+			fmt.Fprintf(progress, "Rejected %d lines in file '%s'\n", len(lines), filepath.Base(sampleFile))
+
+			werr := rejects.Write(sampleFile, lines)
+			if werr != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", werr.Error())
+			}
+		}
+
+		if err != nil {
+			if sw != nil {
+				sampleconverter.AbortSampleWriter(sw)
+				discardOutput(fout, outputFile, existed)
+			}
+			return err
+		}
+	}
+
+	_, err := writer()
+	if err != nil {
+		return err
+	}
+
+	// Writers that spool their samples do most of their work on close
+	err = sw.Close()
+	if err != nil {
+		discardOutput(fout, outputFile, existed)
+		return err
+	}
+
+	return nil
+}
+
+// Remove the unfinished output file of a failed conversion, so it is not taken for a finished one.
+// Files written by the format itself, like databases, are kept if they existed before the conversion,
+// as the writer has rolled back its changes. No output file means stdout, which is left as is
+func discardOutput(fout *os.File, outputFile string, existed bool) {
+
+	if len(outputFile) == 0 {
+		return
+	}
+
+	if fout != nil {
+		fout.Close()
+	} else if existed {
+		return
+	}
+
+	os.Remove(outputFile)
+}
+
+// Convert a single sample file into the sample writer of a merge, passing rejected lines to reject.
+// The sample writer is got from writer once the plugin has loaded. The sample file "-" is read from stdin
+func mergeSampleFile(pluginFile, sampleFile string, writer func() (sampleconverter.SampleWriter, error), reject func(e *sampleconverter.LineError) error) error {
+
+	r := io.Reader(os.Stdin)
+	source := "stdin"
+
+	if sampleFile != "-" {
+
+		fin, err := os.Open(sampleFile)
+		if err != nil {
+			return err
+		}
+		defer fin.Close()
+
+		r = fin
+		source = filepath.Base(sampleFile)
+	}
+
+	sr, err := sampleconverter.NewSampleReader(pluginFile, r, readerOptions(sampleFile))
+	if err != nil {
+		return err
+	}
+
+	sr = sampleconverter.NewSampleReaderSource(sr, source)
+	defer sr.Close()
+
+	sw, err := writer()
+	if err != nil {
+		return err
+	}
+
+	_, err = sampleconverter.ConvertTolerant(sr, sw, reject)
+	return err
+}
+
+// Get a function collecting the lines rejected in a sample file, or nil unless continuing on errors.
+// The function fails when more lines than the max errors flag have been rejected by all conversions
+func rejectLines(lines *[]*sampleconverter.LineError) func(e *sampleconverter.LineError) error {
+
+	if !continueOnError {
+		return nil
+	}
+
+	return func(e *sampleconverter.LineError) error {
+
+		*lines = append(*lines, e)
+
+		n := atomic.AddInt64(&rejectCount, 1)
+		if maxErrors > 0 && n > int64(maxErrors) {
+			return fmt.Errorf("Too many rejected lines, the maximum is %d: %v", maxErrors, e)
+		}
+
+		return nil
+	}
+}
+
+// Get the output file for a sample file from the output directory and template flags.
+// Returns an empty file name if the output file exists and should be skipped
+func outputFileName(format *sampleconverter.Format, pluginFile, sampleFile string) (string, error) {
+
+	outputFile, err := resolveOutputFile(format, templateFileName(format, pluginFile, sampleFile))
+	if err != nil || len(outputFile) == 0 {
+		return outputFile, err
+	}
+
+	// The template can put output files in directories of their own
+	err = os.MkdirAll(filepath.Dir(outputFile), 0777)
+	if err != nil {
+		return "", err
+	}
+
+	return outputFile, nil
+}
+
+// Get the output file for a sample file from the template, next to the sample file or under the output directory.
+// Directories in the template are kept under the output directory
+func templateFileName(format *sampleconverter.Format, pluginFile, sampleFile string) string {
+
+	plugin := strings.TrimSuffix(filepath.Base(pluginFile), filepath.Ext(pluginFile))
+
+	outputFile := format.OutputFileFromTemplate(outputTemplate, sampleFile, plugin, startTime)
+
+	if len(outputDir) > 0 {
+
+		rel, err := filepath.Rel(filepath.Dir(sampleFile), outputFile)
+		if err != nil {
+			rel = filepath.Base(outputFile)
+		}
+
+		outputFile = filepath.Join(outputDir, rel)
+	}
+
+	return outputFile
+}
+
+// Check that no two sample files get the same output file from the template, before any file is converted.
+// Numbered output files never collide, and formats writing to a single database share it on purpose
+func checkOutputFiles(format *sampleconverter.Format, pluginFile string, sampleFiles []string) error {
+
+	if outputExists == "suffix" || (len(useDatabase) > 0 && format.Supports(sampleconverter.OptionDatabase)) {
+		return nil
+	}
+
+	outputs := make(map[string]string)
+	for _, sampleFile := range sampleFiles {
+
+		if sampleFile == "-" {
+			continue
+		}
+
+		outputFile := filepath.Clean(templateFileName(format, pluginFile, sampleFile))
+		if other, ok := outputs[outputFile]; ok {
+			return fmt.Errorf("Sample files %s and %s are both converted to %s. Use -output-exists suffix to number the output files", other, sampleFile, outputFile)
+		}
+
+		outputs[outputFile] = sampleFile
+	}
+
+	return nil
+}
+
+// Apply the output policy flag to an output file that exists.
+// Returns an empty file name if the output file should be skipped
+func resolveOutputFile(format *sampleconverter.Format, outputFile string) (string, error) {
+
+	// Workers must not pick the same numbered file name
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	taken := func(name string) bool {
+		return FileExists(name) || outputFiles[name]
+	}
+
+	if taken(outputFile) {
+		switch outputExists {
+		case "skip":
+			return "", nil
+		case "suffix":
+			outputFile = SuffixFileName(outputFile, format.Extension, taken)
+		}
+	}
+
+	outputFiles[outputFile] = true
+
+	return outputFile, nil
+}
+
+// Create a sample writer for the given output file. No output file means stdout.
+// Unless the format writes the output file itself, the output file is created and returned for the caller to close
+func createSampleWriter(format *sampleconverter.Format, outputFile, source string) (sampleconverter.SampleWriter, *os.File, error) {
+
+	var w io.Writer
+	var fout *os.File
+
+	name := source
+	if len(outputFile) > 0 {
+		name = filepath.Base(outputFile)
+	} else if len(name) == 0 {
+		name = "stdout"
+	}
+
+	if format.WritesFile {
+
+		// The writer creates the output file itself
+		if len(outputFile) == 0 {
+			return nil, nil, errors.New("The " + format.Name + " format can't write to stdout")
+		}
+
+	} else if len(outputFile) == 0 {
+
+		w = os.Stdout
+
+	} else {
+
+		var err error
+		fout, err = os.Create(outputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		w = fout
+	}
+
+	opts := sampleconverter.WriterOptions{
+		Name:          name,
+		Source:        source,
+		OutputFile:    outputFile,
+		UseScientific: useScientific,
+		UseLabels:     useLabels,
+		Channel:       useChannel,
+		UseWaypoints:  useWaypoints,
+		Table:         useTable,
+		Track:         useTrack,
+		AltitudeMode:  useAltitudeMode,
+		ColorScale:    colorScale,
+		Folders:       useFolders,
+	}
+
+	sw, err := sampleconverter.NewSampleWriter(format.Name, w, opts)
+	if err != nil {
+		if fout != nil {
+			fout.Close()
+		}
+		return nil, nil, err
+	}
+
+	return sw, fout, nil
+}
+
+// Get the sample reader options given by the plugin flags, for the named sample file
+func readerOptions(sampleFile string) sampleconverter.ReaderOptions {
+
+	return sampleconverter.ReaderOptions{
+		SampleFile:  sampleFile,
+		LineTimeout: pluginTimeout,
+		FileTimeout: pluginFileTimeout,
+	}
+}
+
+// Get the color scale given by the color scale flags. Flags override the color scale file
+func loadColorScale() (*sampleconverter.ColorScale, error) {
+
+	cs := new(sampleconverter.ColorScale)
+
+	if len(useColorScale) > 0 {
+		var err error
+		cs, err = sampleconverter.LoadColorScale(useColorScale)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(usePalette) == 0 && useClasses == 0 && len(useBinning) == 0 && len(useBreaks) == 0 {
+		return nil, nil
+	}
+
+	if len(usePalette) > 0 {
+		cs.Palette = usePalette
+		cs.Colors = nil
+	}
+
+	if useClasses > 0 {
+		cs.Classes = useClasses
+		cs.Breaks = nil
+	}
+
+	if len(useBinning) > 0 {
+		cs.Binning = useBinning
+		cs.Breaks = nil
+	}
+
+	if len(useBreaks) > 0 {
+		var err error
+		cs.Breaks, err = sampleconverter.ParseBreaks(useBreaks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cs, nil
+}
+
+// Get the names of the color palettes sorted by name
+func paletteNames() []string {
+
+	var names []string
+	for name := range sampleconverter.Palettes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Check if a flag is a writer option
+func isWriterOption(name string) bool {
+
+	for _, f := range sampleconverter.Formats() {
+		if f.Supports(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get a help text suffix listing the formats supporting a writer option
+func formatsSupporting(option string) string {
+
+	var names []string
+	for _, f := range sampleconverter.Formats() {
+		if f.Supports(option) {
+			names = append(names, f.Name)
+		}
+	}
+
+	return " (formats: " + strings.Join(names, ", ") + ")"
+}
@@ -20,6 +20,8 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // FileExists Check if a file exists
@@ -33,11 +35,32 @@ func FileExists(filename string) bool {
 	return true
 }
 
-// ArgumentFiles Get all files listed on the commandline
+// SuffixFileName Get the first file name that isn't taken made by adding _1, _2 etc. before the extension
+func SuffixFileName(fileName, ext string, taken func(name string) bool) string {
+
+	if len(ext) == 0 || !strings.HasSuffix(strings.ToLower(fileName), strings.ToLower(ext)) {
+		ext = filepath.Ext(fileName)
+	}
+
+	stem := fileName[:len(fileName)-len(ext)]
+
+	for i := 1; ; i++ {
+		name := stem + "_" + strconv.Itoa(i) + fileName[len(stem):]
+		if !taken(name) {
+			return name
+		}
+	}
+}
+
+// ArgumentFiles Get all files listed on the commandline. A single "-" is passed through as is
 func ArgumentFiles() []string {
 
 	var allFiles []string
 	for _, pattern := range flag.Args() {
+		if pattern == "-" {
+			allFiles = append(allFiles, pattern)
+			continue
+		}
 		files, _ := filepath.Glob(pattern)
 		allFiles = append(allFiles, files...)
 	}
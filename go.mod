@@ -0,0 +1,22 @@
+module github.com/bytting/SampleConverter
+
+go 1.23.0
+
+require (
+	github.com/robertkrimen/otto v0.2.1
+	golang.org/x/image v0.25.0
+	modernc.org/sqlite v1.28.0
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.9.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
+	gopkg.in/sourcemap.v1 v1.0.5 // indirect
+	modernc.org/libc v1.29.0 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
+)